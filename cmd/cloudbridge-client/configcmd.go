@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	showEffective bool
+	showOutput    string
+	migrateDryRun bool
+	keyFile       string
+)
+
+// newConfigCmd creates the config command group
+func newConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and validate the configuration",
+	}
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration and report every problem",
+		Long: "Validates the merged configuration and checks referenced files, TLS material\n" +
+			"and local tunnel ports. All problems are printed, not only the first one.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return validateConfiguration()
+		},
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the configuration with secrets redacted",
+		Long: "Prints the configuration file, or with --effective the configuration merged from\n" +
+			"defaults, the file and CLOUDBRIDGE_* environment variables. In yaml output every\n" +
+			"effective value is followed by its source: default, file, env or flag. Secrets,\n" +
+			"tokens and keys are replaced with " + config.Redacted + ".",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showConfiguration()
+		},
+	}
+	showCmd.Flags().BoolVar(&showEffective, "effective", false, "Print the merged configuration including defaults and environment")
+	showCmd.Flags().StringVarP(&showOutput, "output", "o", "yaml", "Output format: yaml or json")
+
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the configuration file",
+		Long: "Prints a JSON Schema (draft 2020-12) describing config.yaml for editor completion\n" +
+			"and validation, e.g. with the yaml-language-server $schema modeline.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := config.GenerateSchema().MarshalIndent()
+			if err != nil {
+				return fmt.Errorf("failed to encode schema: %w", err)
+			}
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the configuration file to the current format version",
+		Long: "Rewrites older layouts, including the flat legacy format and the server/tunnel\n" +
+			"format, in the current layout and sets the version field. The original file is\n" +
+			"kept next to it with a .bak suffix.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateConfiguration()
+		},
+	}
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the migrated configuration without writing it")
+
+	encryptCmd := &cobra.Command{
+		Use:   "encrypt-value [value]",
+		Short: "Encrypt a value for the configuration file",
+		Long: "Prints the enc:v1 form of value, or of standard input when no value is given,\n" +
+			"to paste into any string setting such as auth.secret. The key file is created\n" +
+			"when it does not exist: encryption.key_file, or config.key next to the configuration.",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return encryptValue(args)
+		},
+	}
+	encryptCmd.Flags().StringVar(&keyFile, "key-file", "", "Key file (default from configuration)")
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Re-encrypt the configuration with a new key",
+		Long: "Generates a new key, re-encrypts every enc:v1 value of the configuration file\n" +
+			"and replaces the key file. The old key is kept with a .bak suffix; other files\n" +
+			"encrypted with it must be re-encrypted separately.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rotateKey()
+		},
+	}
+	rotateCmd.Flags().StringVar(&keyFile, "key-file", "", "Key file (default from configuration)")
+
+	configCmd.AddCommand(validateCmd, showCmd, schemaCmd, migrateCmd, encryptCmd, rotateCmd)
+	return configCmd
+}
+
+// validateConfiguration prints every problem found in the configuration
+func validateConfiguration() error {
+	loader := newLoader()
+	_, problems, err := loader.Check()
+	var invalid *config.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		problems = invalid.Problems
+	case err != nil:
+		return err
+	}
+
+	source := loader.ConfigFileUsed()
+	if source == "" {
+		source = "defaults and environment"
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Configuration %s is valid\n", source)
+		return nil
+	}
+
+	fmt.Printf("Configuration %s has %d problem(s):\n", source, len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	return fmt.Errorf("invalid configuration")
+}
+
+// showConfiguration prints the file or effective settings in the requested format
+func showConfiguration() error {
+	if err := checkOutputFormat(showOutput); err != nil {
+		return err
+	}
+
+	loader := newLoader()
+	if _, err := loader.ReadUnresolved(); err != nil {
+		return err
+	}
+
+	if !showEffective {
+		fileSettings, err := loader.FileSettings()
+		if err != nil {
+			return err
+		}
+		return printSettings(config.Redact(fileSettings), showOutput)
+	}
+
+	settings := config.Redact(loader.Settings())
+	if showOutput == "yaml" {
+		return printAnnotatedSettings(os.Stdout, settings, loader.Sources())
+	}
+	return printSettings(settings, showOutput)
+}
+
+// checkOutputFormat rejects output formats other than yaml and json
+func checkOutputFormat(output string) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("unsupported output format %q, use yaml or json", output)
+	}
+	return nil
+}
+
+// printSettings writes settings to stdout as yaml or json
+func printSettings(settings map[string]interface{}, output string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(settings)
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(settings); err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return encoder.Close()
+}
+
+// printAnnotatedSettings writes settings to out as yaml with the source of every known key
+// as a line comment, e.g. "port: 8080 # default"
+func printAnnotatedSettings(out io.Writer, settings map[string]interface{}, sources map[string]string) error {
+	var node yaml.Node
+	if err := node.Encode(settings); err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	annotateSources(&node, "", sources)
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return encoder.Close()
+}
+
+// annotateSources comments the values of node below prefix with the source of their key
+func annotateSources(node *yaml.Node, prefix string, sources map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if source, ok := sources[path]; ok {
+			// Block sequences would move the comment below their items
+			if value.Kind == yaml.SequenceNode {
+				value.Style = yaml.FlowStyle
+			}
+			value.LineComment = source
+			continue
+		}
+		annotateSources(value, path, sources)
+	}
+}
+
+// migrateConfiguration upgrades the configuration file in place or prints the result
+func migrateConfiguration() error {
+	path, err := config.NewLoader(configFile).FindConfigFile()
+	if err != nil {
+		return err
+	}
+
+	var result *config.MigrationResult
+	var backup string
+	if migrateDryRun {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		var migrated []byte
+		if result, migrated, err = config.MigrateData(path, data); err != nil {
+			return err
+		}
+		fmt.Print(string(migrated))
+	} else if result, backup, err = config.MigrateFile(path); err != nil {
+		return err
+	}
+
+	for _, step := range result.Steps {
+		fmt.Fprintf(os.Stderr, "Applied migration %s\n", step)
+	}
+	for _, note := range result.Notes {
+		fmt.Fprintf(os.Stderr, "Note: %s\n", note)
+	}
+	switch {
+	case migrateDryRun:
+	case backup == "":
+		fmt.Printf("Configuration %s is already at version %d\n", path, result.To)
+	case !result.Changed():
+		fmt.Printf("Added version %d to %s, original saved as %s\n", result.To, path, backup)
+	default:
+		fmt.Printf("Migrated %s from version %d to %d, original saved as %s\n", path, result.From, result.To, backup)
+	}
+	return nil
+}
+
+// configKeyFile returns the configuration file, if any, and the key file from the flag or configuration
+func configKeyFile() (string, string, error) {
+	loader := config.NewLoader(configFile)
+	path, err := loader.FindConfigFile()
+	if err != nil && configFile != "" {
+		return "", "", err
+	}
+	if keyFile != "" {
+		return path, keyFile, nil
+	}
+	return path, loader.KeyFile(), nil
+}
+
+// encryptValue prints the encrypted form of the argument or standard input
+func encryptValue(args []string) error {
+	var plaintext string
+	if len(args) > 0 {
+		plaintext = args[0]
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read value: %w", err)
+		}
+		plaintext = strings.TrimRight(string(data), "\r\n")
+	}
+	if plaintext == "" {
+		return fmt.Errorf("value is empty")
+	}
+
+	_, path, err := configKeyFile()
+	if err != nil {
+		return err
+	}
+
+	key, err := config.LoadConfigKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if key, err = config.GenerateConfigKey(); err != nil {
+			return err
+		}
+		if err = key.Save(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created key file %s\n", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := key.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	fmt.Println(encrypted)
+	return nil
+}
+
+// rotateKey re-encrypts the configuration file with a new key
+func rotateKey() error {
+	path, keyPath, err := configKeyFile()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return fmt.Errorf("no configuration file found")
+	}
+
+	result, err := config.RotateKey(path, keyPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted %d value(s) in %s with a new key in %s, old key saved as %s\n",
+		result.Values, path, keyPath, result.KeyBackup)
+	return nil
+}
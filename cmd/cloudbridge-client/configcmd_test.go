@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/spf13/pflag"
+)
+
+func TestPrintAnnotatedSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"relay": map[string]interface{}{"host": "edge.example.com", "port": 8080},
+		"auth":  map[string]interface{}{"validation": map[string]interface{}{"audience": []interface{}{"a", "b"}}},
+	}
+	sources := map[string]string{
+		"relay.host":               config.SourceFile,
+		"relay.port":               config.SourceDefault,
+		"auth.validation.audience": config.SourceEnv,
+	}
+
+	var out bytes.Buffer
+	if err := printAnnotatedSettings(&out, settings, sources); err != nil {
+		t.Fatalf("failed to print settings: %v", err)
+	}
+	for _, want := range []string{"host: edge.example.com # file", "port: 8080 # default", "audience: [a, b] # env"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got\n%s", want, out.String())
+		}
+	}
+}
+
+func TestNewLoaderBindsRootFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "auth:\n  secret: x\ncurrent_context: prod\ncontexts:\n" +
+		"  - name: prod\n    relay:\n      host: prod.example.com\n" +
+		"  - name: staging\n    relay:\n      host: staging.example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("context", "", "")
+	if err := flags.Parse([]string{"--context", "staging"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	defer func(previousFile string, previousFlags *pflag.FlagSet) {
+		configFile, rootFlags = previousFile, previousFlags
+	}(configFile, rootFlags)
+	configFile, rootFlags = path, flags
+
+	loader := newLoader()
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.CurrentContext != "staging" || cfg.Relay.Host != "staging.example.com" {
+		t.Errorf("expected --context to select staging, got %s with %s", cfg.CurrentContext, cfg.Relay.Host)
+	}
+	if source := loader.Source("current_context"); source != config.SourceFlag {
+		t.Errorf("expected source of current_context to be %s, got %s", config.SourceFlag, source)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var contextOutput string
+
+// newContextCmd creates the context command group
+func newContextCmd() *cobra.Command {
+	contextCmd := &cobra.Command{
+		Use:   "context",
+		Short: "Switch between relay and auth contexts of the configuration",
+		Long: "Contexts are named bundles of relay, auth and tunnel settings listed under contexts:\n" +
+			"in the configuration. The context in current_context, or the one given with\n" +
+			"--context, is applied over the top-level settings.",
+	}
+
+	listCmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List the configured contexts",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listContexts()
+		},
+	}
+
+	useCmd := &cobra.Command{
+		Use:          "use <name>",
+		Short:        "Set current_context in the configuration file",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return useContext(args[0])
+		},
+	}
+
+	showCmd := &cobra.Command{
+		Use:          "show [name]",
+		Short:        "Print the effective settings of a context with secrets redacted",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return showContext(name)
+		},
+	}
+	showCmd.Flags().StringVarP(&contextOutput, "output", "o", "yaml", "Output format: yaml or json")
+
+	contextCmd.AddCommand(listCmd, useCmd, showCmd)
+	return contextCmd
+}
+
+// listContexts prints every context, marking the selected one
+func listContexts() error {
+	cfg, err := newLoader().ReadUnresolved()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Contexts) == 0 {
+		fmt.Println("No contexts configured")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tRELAY\tAUTH\tTUNNELS")
+	for _, ctx := range cfg.Contexts {
+		current := ""
+		if ctx.Name == cfg.CurrentContext {
+			current = "*"
+		}
+		relayAddr := "-"
+		if ctx.Relay.Host != "" {
+			relayAddr = ctx.Relay.Host
+			if ctx.Relay.Port != 0 {
+				relayAddr = fmt.Sprintf("%s:%d", ctx.Relay.Host, ctx.Relay.Port)
+			}
+		}
+		authType := ctx.Auth.Type
+		if authType == "" {
+			authType = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", current, ctx.Name, relayAddr, authType, len(ctx.Tunnels))
+	}
+	return w.Flush()
+}
+
+// useContext makes name the current context of the configuration file
+func useContext(name string) error {
+	// The file is not loaded, so a broken current_context can still be replaced
+	path, err := config.NewLoader(configFile).FindConfigFile()
+	if err != nil {
+		return err
+	}
+	if err := config.SetCurrentContext(path, name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Switched to context %q in %s\n", name, path)
+	return nil
+}
+
+// showContext prints the relay, auth and tunnel settings in effect for a context
+func showContext(name string) error {
+	if err := checkOutputFormat(contextOutput); err != nil {
+		return err
+	}
+
+	loader := newLoader()
+	if name != "" {
+		loader.SetContext(name)
+	}
+	cfg, err := loader.ReadUnresolved()
+	if err != nil {
+		return err
+	}
+
+	all := loader.Settings()
+	settings := map[string]interface{}{"context": cfg.CurrentContext}
+	for _, key := range []string{"relay", "auth", "tunnels"} {
+		settings[key] = all[key]
+	}
+	return printSettings(config.Redact(settings), contextOutput)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
+	"github.com/spf13/cobra"
+)
+
+var (
+	credStorePath string
+	credKeyFile   string
+)
+
+// newCredentialsCmd creates the credentials command group
+func newCredentialsCmd() *cobra.Command {
+	credentialsCmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the encrypted credential store",
+	}
+
+	credentialsCmd.PersistentFlags().StringVar(&credStorePath, "store", "", "Credential store path (default $HOME/.cloudbridge-client/credentials.json)")
+	credentialsCmd.PersistentFlags().StringVar(&credKeyFile, "key-file", "", "Key file used to encrypt the store (default $"+auth.CredentialPassphraseEnv+")")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored identities",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openCredentialStore(credStorePath, credKeyFile)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "IDENTITY\tEXPIRES\tUPDATED")
+			for _, info := range store.List() {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", info.Identity, formatTime(info.ExpiresAt), formatTime(info.UpdatedAt))
+			}
+			return w.Flush()
+		},
+	}
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke <identity>",
+		Short: "Remove a stored identity",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openCredentialStore(credStorePath, credKeyFile)
+			if err != nil {
+				return err
+			}
+
+			if err := store.Revoke(args[0]); err != nil {
+				return err
+			}
+
+			fmt.Printf("Revoked credentials for identity %s\n", args[0])
+			return nil
+		},
+	}
+
+	credentialsCmd.AddCommand(listCmd, revokeCmd)
+	return credentialsCmd
+}
+
+// openCredentialStore opens the credential store, falling back to the default path
+func openCredentialStore(path, keyFile string) (*auth.CredentialStore, error) {
+	if path == "" {
+		defaultPath, err := auth.DefaultCredentialStorePath()
+		if err != nil {
+			return nil, err
+		}
+		path = defaultPath
+	}
+
+	secret, err := auth.LoadStoreSecret(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.OpenCredentialStore(path, secret)
+}
+
+// formatTime formats a timestamp for table output
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/enroll"
+	"github.com/2gc-dev/cloudbridge-client/pkg/supervisor"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bootstrapToken     string
+	bootstrapTokenFile string
+	enrollURL          string
+	enrollName         string
+	enrollTenant       string
+	enrollDNSNames     []string
+	enrollCertFile     string
+	enrollKeyFile      string
+)
+
+// newEnrollCmd creates the enroll command
+func newEnrollCmd() *cobra.Command {
+	enrollCmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "Obtain a client certificate from the relay",
+		Long: "Generates a private key and CSR locally, submits the CSR to the relay enrollment\n" +
+			"endpoint with a one-time bootstrap token and stores the issued certificate next to\n" +
+			"the configuration. The client renews the certificate automatically before expiry.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         runEnroll,
+	}
+
+	enrollCmd.Flags().StringVar(&bootstrapToken, "bootstrap-token", "", "One-time bootstrap token")
+	enrollCmd.Flags().StringVar(&bootstrapTokenFile, "bootstrap-token-file", "", "Read the bootstrap token from a file ('-' for stdin)")
+	enrollCmd.Flags().StringVar(&enrollURL, "url", "", "Enrollment endpoint (default relay.tls.enrollment.url)")
+	enrollCmd.Flags().StringVar(&enrollName, "name", "", "Certificate common name (default hostname)")
+	enrollCmd.Flags().StringVar(&enrollTenant, "tenant", "", "Tenant ID placed in the certificate OU")
+	enrollCmd.Flags().StringSliceVar(&enrollDNSNames, "dns", nil, "DNS names to include in the certificate")
+	enrollCmd.Flags().StringVar(&enrollCertFile, "cert", "", "Certificate output path (default relay.tls.client_cert or client.crt next to the config)")
+	enrollCmd.Flags().StringVar(&enrollKeyFile, "key", "", "Private key output path (default relay.tls.client_key or client.key next to the config)")
+
+	return enrollCmd
+}
+
+func runEnroll(cmd *cobra.Command, args []string) error {
+	loader := newLoader()
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	secret, err := readBootstrapToken()
+	if err != nil {
+		return err
+	}
+
+	url := enrollURL
+	if url == "" {
+		url = cfg.Relay.TLS.Enrollment.URL
+	}
+	if url == "" {
+		return fmt.Errorf("enrollment URL is required (--url or relay.tls.enrollment.url)")
+	}
+
+	name := enrollName
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to determine hostname: %w", err)
+		}
+	}
+
+	certFile, keyFile := enrollmentPaths(cfg, loader.ConfigDir())
+
+	tlsConfig, err := enroll.TLSConfig(cfg, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to create TLS config: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Relay.Timeout)
+	defer cancel()
+
+	client := enroll.NewClient(url, tlsConfig, cfg.Relay.Timeout)
+	pair, err := client.Enroll(ctx, enroll.Subject{
+		CommonName: name,
+		TenantID:   enrollTenant,
+		DNSNames:   enrollDNSNames,
+	}, secret)
+	if err != nil {
+		return err
+	}
+
+	if err := enroll.WriteKeyPair(certFile, keyFile, pair); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enrolled %s\n", pair.Certificate.Subject)
+	fmt.Printf("  certificate: %s\n", certFile)
+	fmt.Printf("  private key: %s\n", keyFile)
+	fmt.Printf("  valid until: %s\n", pair.Certificate.NotAfter.Format(time.RFC3339))
+
+	if cfg.Relay.TLS.ClientCert != certFile || cfg.Relay.TLS.Enrollment.URL != url {
+		fmt.Println("\nAdd to the configuration to use and renew the certificate:")
+		fmt.Printf("relay:\n  tls:\n    client_cert: %q\n    client_key: %q\n    enrollment:\n      url: %q\n", certFile, keyFile, url)
+	}
+
+	return nil
+}
+
+// readBootstrapToken returns the bootstrap token from the flag or token file
+func readBootstrapToken() (string, error) {
+	if bootstrapToken != "" {
+		return bootstrapToken, nil
+	}
+	if bootstrapTokenFile == "" {
+		return "", fmt.Errorf("bootstrap token is required (--bootstrap-token or --bootstrap-token-file)")
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if bootstrapTokenFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(bootstrapTokenFile)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read bootstrap token: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// enrollmentPaths returns where the key pair is stored
+func enrollmentPaths(cfg *types.Config, configDir string) (string, string) {
+	certFile, keyFile := enrollCertFile, enrollKeyFile
+	if certFile == "" {
+		certFile = cfg.Relay.TLS.ClientCert
+	}
+	if keyFile == "" {
+		keyFile = cfg.Relay.TLS.ClientKey
+	}
+	if certFile == "" {
+		certFile = filepath.Join(configDir, "client.crt")
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(configDir, "client.key")
+	}
+	return certFile, keyFile
+}
+
+// newCertificateRenewer returns a renewer for the enrolled client certificate,
+// or nil when enrollment is not configured
+func newCertificateRenewer(cfg *types.Config) (*enroll.Renewer, error) {
+	tlsCfg := cfg.Relay.TLS
+	if !tlsCfg.Enabled || tlsCfg.Enrollment.URL == "" || tlsCfg.Enrollment.RenewDisabled || tlsCfg.ClientCert == "" {
+		return nil, nil
+	}
+
+	tlsConfig, err := enroll.TLSConfig(cfg, tlsCfg.ClientCert, tlsCfg.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client := enroll.NewClient(tlsCfg.Enrollment.URL, tlsConfig, cfg.Relay.Timeout)
+	return enroll.NewRenewer(client, tlsCfg.ClientCert, tlsCfg.ClientKey, tlsCfg.Enrollment.RenewBefore), nil
+}
+
+// identityRenewers creates a renewer for the enrolled client certificate of every identity.
+// Identities sharing a certificate file share its renewer.
+func identityRenewers(cfg *types.Config) ([]*enroll.Renewer, error) {
+	var renewers []*enroll.Renewer
+	seen := make(map[string]bool)
+	for _, identity := range cfg.Identities {
+		identityCfg := supervisor.MergeIdentityConfig(cfg, identity)
+		certFile := identityCfg.Relay.TLS.ClientCert
+		if seen[certFile] {
+			continue
+		}
+		renewer, err := newCertificateRenewer(identityCfg)
+		if err != nil {
+			return nil, fmt.Errorf("identity %s: %w", identity.Name, err)
+		}
+		if renewer != nil {
+			seen[certFile] = true
+			renewers = append(renewers, renewer)
+		}
+	}
+	return renewers, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+func TestIdentityRenewers(t *testing.T) {
+	cfg := &types.Config{}
+	cfg.Relay.TLS.Enabled = true
+	cfg.Relay.TLS.ClientCert = "shared.crt"
+	cfg.Relay.TLS.ClientKey = "shared.key"
+	cfg.Relay.TLS.Enrollment.URL = "https://enroll.example.com"
+	cfg.Identities = []types.IdentityConfig{
+		{Name: "a", Token: "token-a"},
+		{Name: "b", Token: "token-b"},
+		{Name: "c", Token: "token-c", Relay: types.RelayConfig{TLS: types.TLSConfig{ClientCert: "c.crt", ClientKey: "c.key"}}},
+	}
+
+	renewers, err := identityRenewers(cfg)
+	if err != nil {
+		t.Fatalf("failed to create renewers: %v", err)
+	}
+	if len(renewers) != 2 {
+		t.Errorf("expected one renewer per certificate file, got %d", len(renewers))
+	}
+
+	cfg.Relay.TLS.Enrollment.RenewDisabled = true
+	if renewers, err = identityRenewers(cfg); err != nil || len(renewers) != 0 {
+		t.Errorf("expected no renewers with renewal disabled, got %d (%v)", len(renewers), err)
+	}
+}
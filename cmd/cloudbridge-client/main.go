@@ -7,48 +7,71 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
 	"github.com/2gc-dev/cloudbridge-client/pkg/config"
 	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
 	"github.com/2gc-dev/cloudbridge-client/pkg/relay"
+	"github.com/2gc-dev/cloudbridge-client/pkg/supervisor"
 	"github.com/2gc-dev/cloudbridge-client/pkg/types"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
 	configFile string
 	token      string
 	tunnelID   string
+	bind       string
 	localPort  int
 	remoteHost string
 	remotePort int
 	verbose    bool
+	// rootFlags holds the root command flags bound to configuration keys
+	rootFlags *pflag.FlagSet
 )
 
+// flagKeys maps root command flags to the configuration keys they override
+var flagKeys = map[string]string{
+	"context": "current_context",
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "cloudbridge-client",
 		Short: "CloudBridge Relay Client",
 		Long:  "A cross-platform client for CloudBridge Relay with TLS 1.3 support and JWT authentication",
 		RunE:  run,
+		// Errors are printed by main
+		SilenceErrors: true,
 	}
 
 	// Add flags
-	rootCmd.Flags().StringVarP(&configFile, "config", "c", "", "Configuration file path")
-	rootCmd.Flags().StringVarP(&token, "token", "t", "", "JWT token for authentication")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Configuration file path")
+	rootCmd.PersistentFlags().String("context", "", "Configuration context to use instead of current_context")
+	rootCmd.Flags().StringVarP(&token, "token", "t", "", "JWT token for authentication (not required with mtls auth)")
 	rootCmd.Flags().StringVarP(&tunnelID, "tunnel-id", "i", "tunnel_001", "Tunnel ID")
+	rootCmd.Flags().StringVar(&bind, "bind", "", "Local address or unix:///path.sock to listen on (default 127.0.0.1)")
 	rootCmd.Flags().IntVarP(&localPort, "local-port", "l", 3389, "Local port to bind")
 	rootCmd.Flags().StringVarP(&remoteHost, "remote-host", "r", "192.168.1.100", "Remote host")
 	rootCmd.Flags().IntVarP(&remotePort, "remote-port", "p", 3389, "Remote port")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
 
-	// Mark required flags
-	if err := rootCmd.MarkFlagRequired("token"); err != nil {
-		fmt.Fprintf(os.Stderr, "Error marking flag required: %v\n", err)
-		os.Exit(1)
-	}
+	// Subcommands share the persistent flags, so their values are seen through rootFlags
+	rootFlags = pflag.NewFlagSet(rootCmd.Name(), pflag.ContinueOnError)
+	rootFlags.AddFlagSet(rootCmd.PersistentFlags())
+	rootFlags.AddFlagSet(rootCmd.Flags())
+
+	// Add subcommands
+	rootCmd.AddCommand(newCredentialsCmd())
+	rootCmd.AddCommand(newTokenCmd())
+	rootCmd.AddCommand(newTLSCmd())
+	rootCmd.AddCommand(newEnrollCmd())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(newContextCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -56,21 +79,69 @@ func main() {
 	}
 }
 
+// newLoader creates the configuration loader for the --config flag,
+// with the root flags of flagKeys such as --context overriding their keys when set
+func newLoader() *config.Loader {
+	loader := config.NewLoader(configFile)
+	if rootFlags != nil {
+		for name, key := range flagKeys {
+			if flag := rootFlags.Lookup(name); flag != nil {
+				if err := loader.BindFlag(key, flag); err != nil {
+					_ = err // Флаг зарегистрирован выше
+				}
+			}
+		}
+	}
+	return loader
+}
+
 func run(cmd *cobra.Command, args []string) error {
 	// Log platform information
 	log.Printf("Running on %s/%s", runtime.GOOS, runtime.GOARCH)
 
 	// Load configuration
-	cfg, err := config.LoadConfig(configFile)
+	loader := newLoader()
+	cfg, err := loader.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Override config with command line flags if provided
-	if token != "" {
-		cfg.Auth.Secret = token // For JWT auth, secret is the token
+	// Run every configured identity under a supervisor
+	if len(cfg.Identities) > 0 {
+		return runSupervisor(loader, cfg)
 	}
 
+	// Client certificate authentication does not use tokens
+	certAuth := cfg.Auth.Type == "mtls"
+
+	// Open the credential store if token caching is enabled
+	var credStore *auth.CredentialStore
+	if cfg.Auth.CredentialStore.Enabled && !certAuth {
+		credStore, err = openCredentialStore(cfg.Auth.CredentialStore.Path, cfg.Auth.CredentialStore.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to open credential store: %w", err)
+		}
+	}
+
+	// Fall back to the cached token when none is given on the command line
+	if token == "" && credStore != nil {
+		cred, loadErr := credStore.Load(cfg.Auth.CredentialStore.Identity)
+		if loadErr != nil {
+			return fmt.Errorf("no token provided and no cached credential: %w", loadErr)
+		}
+		if cred.Expired() {
+			return fmt.Errorf("cached token for identity %s has expired", cred.Identity)
+		}
+		token = cred.AccessToken
+		log.Printf("Using cached token for identity %s", cred.Identity)
+	}
+
+	if token == "" && !certAuth {
+		return fmt.Errorf("token is required")
+	}
+
+	relay.ApplyToken(cfg, token)
+
 	// Create client
 	client, err := relay.NewClient(cfg)
 	if err != nil {
@@ -86,11 +157,15 @@ func run(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	sigChan := make(chan os.Signal, 1)
-	if runtime.GOOS == types.PlatformWindows {
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	} else {
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	sigChan := shutdownSignals()
+
+	// Renew the enrolled client certificate in the background
+	renewer, err := newCertificateRenewer(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up certificate renewal: %w", err)
+	}
+	if renewer != nil {
+		go renewer.Run(ctx)
 	}
 
 	// Start connection with retry logic
@@ -107,13 +182,32 @@ func run(cmd *cobra.Command, args []string) error {
 
 	log.Printf("Successfully authenticated with client ID: %s", client.GetClientID())
 
-	// Create tunnel
-	if err := createTunnelWithRetry(client, tunnelID, localPort, remoteHost, remotePort); err != nil {
-		return fmt.Errorf("failed to create tunnel: %w", err)
+	// Cache the token for subsequent restarts
+	if credStore != nil {
+		cred := auth.NewCredentialFromToken(cfg.Auth.CredentialStore.Identity, token)
+		if err := credStore.Save(cred); err != nil {
+			log.Printf("Failed to cache credential: %v", err)
+		}
 	}
 
-	log.Printf("Successfully created tunnel %s: localhost:%d -> %s:%d",
-		tunnelID, localPort, remoteHost, remotePort)
+	// Create the declared tunnels, plus the one given by flags
+	var flagTunnels []types.TunnelConfig
+	if len(cfg.Tunnels) == 0 || tunnelFlagsChanged(cmd) {
+		flagTunnels = []types.TunnelConfig{{
+			ID:         tunnelID,
+			Bind:       bind,
+			LocalPort:  localPort,
+			RemoteHost: remoteHost,
+			RemotePort: remotePort,
+		}}
+		// Unix socket tunnels have no local port
+		if flagTunnels[0].IsUnixSocket() {
+			flagTunnels[0].LocalPort = 0
+		}
+	}
+	if err := createTunnels(client, append(append([]types.TunnelConfig{}, flagTunnels...), cfg.Tunnels...)); err != nil {
+		return err
+	}
 
 	// Start heartbeat
 	if err := client.StartHeartbeat(); err != nil {
@@ -122,15 +216,79 @@ func run(cmd *cobra.Command, args []string) error {
 
 	log.Printf("Heartbeat started")
 
-	// Wait for shutdown signal
-	select {
-	case <-sigChan:
-		log.Println("Received shutdown signal, closing...")
-	case <-ctx.Done():
-		log.Println("Context canceled, closing...")
+	// Wait for shutdown signal, applying configuration changes meanwhile
+	reloads := reloadTriggers(loader, ctx.Done())
+	for {
+		select {
+		case <-reloads:
+			cfg = reloadTunnels(loader, client, cfg, flagTunnels)
+		case <-sigChan:
+			log.Println("Received shutdown signal, draining tunnels...")
+			return nil
+		case <-client.Done():
+			return fmt.Errorf("relay session ended")
+		case <-ctx.Done():
+			log.Println("Context canceled, closing...")
+			return nil
+		}
+	}
+}
+
+// runSupervisor runs all configured identities until a shutdown signal is received
+func runSupervisor(loader *config.Loader, cfg *types.Config) error {
+	sup, err := supervisor.NewSupervisor(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create supervisor: %w", err)
 	}
+	defer sup.Stop()
 
-	return nil
+	sigChan := shutdownSignals()
+
+	// Renew the enrolled client certificates of the identities in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	renewers, err := identityRenewers(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up certificate renewal: %w", err)
+	}
+	for _, renewer := range renewers {
+		go renewer.Run(ctx)
+	}
+
+	if err := sup.Start(); err != nil {
+		return err
+	}
+
+	for _, identity := range sup.Identities() {
+		if identity.Err() == nil {
+			log.Printf("Identity %s started (tenant %s)", identity.Name, identity.Client().GetTenantID())
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	reloads := reloadTriggers(loader, stop)
+	for {
+		select {
+		case <-reloads:
+			cfg = reloadSupervisor(loader, sup, cfg)
+		case <-sigChan:
+			log.Println("Received shutdown signal, draining tunnels...")
+			return nil
+		}
+	}
+}
+
+// shutdownSignals returns a channel notified on the platform's shutdown signals
+func shutdownSignals() chan os.Signal {
+	sigChan := make(chan os.Signal, 1)
+	if runtime.GOOS == types.PlatformWindows {
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	} else {
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	}
+	return sigChan
 }
 
 // connectWithRetry connects to the relay server with retry logic
@@ -175,12 +333,54 @@ func authenticateWithRetry(client *relay.Client, token string) error {
 	}
 }
 
+// tunnelFlagsChanged reports whether a tunnel was given on the command line
+func tunnelFlagsChanged(cmd *cobra.Command) bool {
+	for _, name := range []string{"tunnel-id", "bind", "local-port", "remote-host", "remote-port"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// createTunnels creates every enabled tunnel and reports failures per tunnel.
+// An error is returned only when none of the tunnels could be created.
+func createTunnels(client *relay.Client, tunnels []types.TunnelConfig) error {
+	var created int
+	var failed []string
+	for _, t := range tunnels {
+		if !t.IsEnabled() {
+			log.Printf("Tunnel %s is disabled, skipping", t.ID)
+			continue
+		}
+
+		if err := createTunnelWithRetry(client, t); err != nil {
+			log.Printf("Failed to create tunnel %s: %v", t.ID, err)
+			failed = append(failed, t.ID)
+			continue
+		}
+
+		created++
+		log.Printf("Successfully created tunnel %s: %s -> %s:%d",
+			t.ID, t.ListenAddress(), t.RemoteHost, t.RemotePort)
+	}
+
+	if len(failed) > 0 {
+		if created == 0 {
+			return fmt.Errorf("failed to create tunnels: %s", strings.Join(failed, ", "))
+		}
+		log.Printf("%d of %d tunnels failed: %s", len(failed), created+len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
 // createTunnelWithRetry creates a tunnel with retry logic
-func createTunnelWithRetry(client *relay.Client, tunnelID string, localPort int, remoteHost string, remotePort int) error {
+func createTunnelWithRetry(client *relay.Client, tunnel types.TunnelConfig) error {
 	retryStrategy := client.GetRetryStrategy()
 
 	for {
-		err := client.CreateTunnel(tunnelID, localPort, remoteHost, remotePort)
+		err := client.CreateTunnelFromConfig(tunnel)
 		if err == nil {
 			return nil
 		}
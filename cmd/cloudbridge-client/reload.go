@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/2gc-dev/cloudbridge-client/pkg/relay"
+	"github.com/2gc-dev/cloudbridge-client/pkg/supervisor"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// reloadDebounce coalesces the burst of file events produced by a single save
+const reloadDebounce = 500 * time.Millisecond
+
+// reloadTriggers returns a channel notified on SIGHUP or configuration file changes
+func reloadTriggers(loader *config.Loader, stop <-chan struct{}) <-chan struct{} {
+	triggers := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case triggers <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			notify()
+		}
+	}()
+
+	if err := loader.Watch(notify, stop); err != nil {
+		log.Printf("Configuration file changes will not be applied automatically: %v", err)
+	}
+
+	return debounce(triggers)
+}
+
+// debounce forwards a trigger once no new trigger arrived for reloadDebounce
+func debounce(in <-chan struct{}) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	go func() {
+		for range in {
+			timer := time.NewTimer(reloadDebounce)
+		wait:
+			for {
+				select {
+				case <-in:
+					timer.Reset(reloadDebounce)
+				case <-timer.C:
+					break wait
+				}
+			}
+			select {
+			case out <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return out
+}
+
+// reloadTunnels re-reads the configuration and applies tunnel changes to the client.
+// An invalid configuration is rejected as a whole and the running tunnels are kept.
+// extra are tunnels given on the command line, which stay in place across reloads.
+func reloadTunnels(loader *config.Loader, client *relay.Client, current *types.Config, extra []types.TunnelConfig) *types.Config {
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Printf("Configuration reload rejected: %v", err)
+		return current
+	}
+	warnRestartRequired(current, cfg)
+
+	desired := append(append([]types.TunnelConfig{}, extra...), cfg.Tunnels...)
+	diff, err := client.ApplyTunnels(desired)
+	if diff == nil {
+		log.Printf("Configuration reload rejected: %v", err)
+		return current
+	}
+	client.SetConnectionLimits(cfg.Connections)
+
+	log.Printf("Configuration reloaded: %d tunnels added, %d changed, %d updated, %d removed, %d unchanged",
+		len(diff.Added), len(diff.Changed), len(diff.Updated), len(diff.Removed), len(diff.Unchanged))
+	if err != nil {
+		log.Printf("Some tunnels could not be applied: %v", err)
+	}
+
+	return cfg
+}
+
+// reloadSupervisor re-reads the configuration and applies tunnel changes to every identity
+func reloadSupervisor(loader *config.Loader, sup *supervisor.Supervisor, current *types.Config) *types.Config {
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Printf("Configuration reload rejected: %v", err)
+		return current
+	}
+	warnRestartRequired(current, cfg)
+
+	if err := sup.Reload(cfg); err != nil {
+		log.Printf("Configuration reload incomplete: %v", err)
+	} else {
+		log.Println("Configuration reloaded")
+	}
+
+	return cfg
+}
+
+// warnRestartRequired logs settings that changed but only take effect after a restart
+func warnRestartRequired(current, next *types.Config) {
+	if !reflect.DeepEqual(current.Relay, next.Relay) {
+		log.Println("Relay settings changed, restart to apply them")
+	}
+	if current.Auth.Type != next.Auth.Type {
+		log.Println("Authentication type changed, restart to apply it")
+	}
+	if (len(current.Identities) > 0) != (len(next.Identities) > 0) {
+		log.Println("Switching between single and multi-identity mode requires a restart")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pinAddress    string
+	pinServerName string
+)
+
+// newTLSCmd creates the tls command group
+func newTLSCmd() *cobra.Command {
+	tlsCmd := &cobra.Command{
+		Use:   "tls",
+		Short: "TLS diagnostics for the relay connection",
+	}
+
+	pinCmd := &cobra.Command{
+		Use:   "pin",
+		Short: "Print the SPKI pins of the relay certificate chain",
+		Long: "Connects to the relay and prints the base64 SHA-256 SPKI hash of every certificate\n" +
+			"in the presented chain, suitable for relay.tls.pins and relay.tls.backup_pins.\n" +
+			"The chain is fetched without verification; compare it against a trusted source.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			address, serverName, err := pinTarget()
+			if err != nil {
+				return err
+			}
+			return printPins(address, serverName)
+		},
+	}
+	pinCmd.Flags().StringVar(&pinAddress, "addr", "", "Relay address host:port (default from configuration)")
+	pinCmd.Flags().StringVar(&pinServerName, "server-name", "", "TLS server name (default from configuration or address host)")
+
+	tlsCmd.AddCommand(pinCmd)
+	return tlsCmd
+}
+
+// pinTarget returns the relay address and server name from flags or configuration
+func pinTarget() (string, string, error) {
+	if pinAddress != "" {
+		serverName := pinServerName
+		if serverName == "" {
+			host, _, err := net.SplitHostPort(pinAddress)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid address %q: %w", pinAddress, err)
+			}
+			serverName = host
+		}
+		return pinAddress, serverName, nil
+	}
+
+	cfg, err := newLoader().Load()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	serverName := pinServerName
+	if serverName == "" {
+		serverName = cfg.Relay.TLS.ServerName
+	}
+	if serverName == "" {
+		serverName = cfg.Relay.Host
+	}
+
+	return net.JoinHostPort(cfg.Relay.Host, strconv.Itoa(cfg.Relay.Port)), serverName, nil
+}
+
+// printPins connects to address and prints the pin of each presented certificate
+func printPins(address, serverName string) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName: serverName,
+		// Only used to read the chain, nothing is sent over this connection
+		InsecureSkipVerify: true, // #nosec G402
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия диагностического соединения
+		}
+	}()
+
+	fmt.Printf("Certificate chain presented by %s:\n", address)
+	for i, cert := range conn.ConnectionState().PeerCertificates {
+		fmt.Printf("\n[%d] %s\n", i, cert.Subject)
+		fmt.Printf("    issuer:    %s\n", cert.Issuer)
+		fmt.Printf("    not after: %s\n", cert.NotAfter.Format(time.RFC3339))
+		fmt.Printf("    pin:       %s\n", config.SPKIPin(cert))
+	}
+
+	return nil
+}
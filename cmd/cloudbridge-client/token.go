@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/2gc-dev/cloudbridge-client/pkg/relay"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenValue     string
+	tokenFile      string
+	tokenIdentity  string
+	expectedTenant string
+)
+
+// timeClaims are the registered claims holding NumericDate values
+var timeClaims = []string{"iat", "nbf", "exp"}
+
+// newTokenCmd creates the token command group
+func newTokenCmd() *cobra.Command {
+	tokenCmd := &cobra.Command{
+		Use:   "token",
+		Short: "Inspect and verify authentication tokens",
+	}
+
+	tokenCmd.PersistentFlags().StringVarP(&tokenValue, "token", "t", "", "Token to inspect")
+	tokenCmd.PersistentFlags().StringVar(&tokenFile, "token-file", "", "Read the token from a file ('-' for stdin)")
+	tokenCmd.PersistentFlags().StringVar(&tokenIdentity, "identity", "", "Read the token from the credential store identity")
+	tokenCmd.PersistentFlags().StringVar(&credStorePath, "store", "", "Credential store path (default $HOME/.cloudbridge-client/credentials.json)")
+	tokenCmd.PersistentFlags().StringVar(&credKeyFile, "key-file", "", "Key file used to encrypt the store (default $"+auth.CredentialPassphraseEnv+")")
+
+	inspectCmd := &cobra.Command{
+		Use:   "inspect [token]",
+		Short: "Decode a token and show its header and claims without verifying it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokenString, err := resolveToken(args)
+			if err != nil {
+				return err
+			}
+			return inspectToken(os.Stdout, tokenString)
+		},
+	}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify [token]",
+		Short: "Validate a token with the configured authentication settings",
+		Args:  cobra.MaximumNArgs(1),
+		// Verification failures are reported per check, usage would only add noise
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokenString, err := resolveToken(args)
+			if err != nil {
+				return err
+			}
+			return verifyToken(tokenString)
+		},
+	}
+	verifyCmd.Flags().StringVar(&expectedTenant, "tenant", "", "Expected tenant_id")
+
+	tokenCmd.AddCommand(inspectCmd, verifyCmd)
+	return tokenCmd
+}
+
+// resolveToken returns the token from the argument, flag, file or credential store
+func resolveToken(args []string) (string, error) {
+	switch {
+	case len(args) == 1:
+		return strings.TrimSpace(args[0]), nil
+	case tokenValue != "":
+		return strings.TrimSpace(tokenValue), nil
+	case tokenFile != "":
+		var data []byte
+		var err error
+		if tokenFile == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(tokenFile)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case tokenIdentity != "":
+		store, err := openCredentialStore(credStorePath, credKeyFile)
+		if err != nil {
+			return "", err
+		}
+		cred, err := store.Load(tokenIdentity)
+		if err != nil {
+			return "", err
+		}
+		return cred.AccessToken, nil
+	default:
+		return "", fmt.Errorf("no token provided, use an argument, --token, --token-file or --identity")
+	}
+}
+
+// inspectToken prints the decoded header and claims to out
+func inspectToken(out io.Writer, tokenString string) error {
+	claims := jwt.MapClaims{}
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, claims)
+	if err != nil {
+		return fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	header, err := json.MarshalIndent(token.Header, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %w", err)
+	}
+	body, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	fmt.Fprintf(out, "Header:\n%s\n\nClaims:\n%s\n", header, body)
+
+	var times []string
+	for _, name := range timeClaims {
+		if value, ok := claims[name].(float64); ok {
+			t := time.Unix(int64(value), 0)
+			times = append(times, fmt.Sprintf("  %-4s %s (%s)", name, t.Local().Format(time.RFC3339), relativeTime(t)))
+		}
+	}
+	if len(times) > 0 {
+		fmt.Fprintf(out, "\nTimes:\n%s\n", strings.Join(times, "\n"))
+	}
+
+	return nil
+}
+
+// verifyToken validates the token with the configured auth manager and reports the failing check
+func verifyToken(tokenString string) error {
+	cfg, err := newLoader().Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return checkToken(os.Stdout, relay.NewAuthConfig(cfg), tokenString, expectedTenant)
+}
+
+// checkToken validates tokenString with authConfig and prints one line per check to out.
+// Checks the policy does not configure are reported as such instead of as passed.
+func checkToken(out io.Writer, authConfig *auth.AuthConfig, tokenString, tenant string) error {
+	am, err := auth.NewAuthManager(authConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create auth manager: %w", err)
+	}
+	report := func(status, check, detail string) {
+		fmt.Fprintf(out, "%-8s%-9s %s\n", status, check, detail)
+	}
+
+	token, err := am.ValidateToken(tokenString)
+	if err != nil {
+		check := "format"
+		code := errors.ErrInvalidToken
+		if relayErr, ok := err.(*errors.RelayError); ok {
+			code = relayErr.Code
+			check = failedCheck(relayErr)
+		}
+		report("FAILED", check, err.Error())
+		return fmt.Errorf("token verification failed: %s", code)
+	}
+
+	tenantID, err := am.ExtractTenantID(token)
+	if err != nil {
+		return err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	policy := am.Policy()
+
+	// Key IDs select a provider key, HMAC tokens are checked against the shared secret
+	if kid, ok := token.Header["kid"].(string); ok && am.Type() != "jwt" {
+		report("ok", "kid", kid)
+	}
+	report("ok", "signature", token.Method.Alg())
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		report("ok", "expiry", "expires "+exp.Local().Format(time.RFC3339))
+	} else {
+		report("warning", "expiry", "token has no exp claim and does not expire")
+	}
+	if policy.MaxTokenAge > 0 {
+		report("ok", "age", "issued within "+policy.MaxTokenAge.String())
+	} else {
+		report("skipped", "age", "not configured")
+	}
+	if policy.Issuer != "" {
+		report("ok", "issuer", policy.Issuer)
+	} else {
+		report("skipped", "issuer", "not configured")
+	}
+	if len(policy.Audience) > 0 {
+		report("ok", "audience", strings.Join(policy.Audience, ", "))
+	} else {
+		report("skipped", "audience", "not configured")
+	}
+	if policy.AuthorizedParty != "" {
+		report("ok", "client", policy.AuthorizedParty+" in azp or aud")
+	}
+	if len(policy.RequiredClaims) > 0 {
+		report("ok", "claims", strings.Join(policy.RequiredClaims, ", "))
+	} else {
+		report("skipped", "claims", "not configured")
+	}
+
+	switch {
+	case tenant != "" && tenantID != tenant:
+		report("FAILED", "tenant", fmt.Sprintf("expected %s, got %q", tenant, tenantID))
+		return fmt.Errorf("token verification failed: %s", errors.ErrTenantNotFound)
+	case tenantID == "":
+		report("warning", "tenant", "token has no tenant_id claim")
+	default:
+		report("ok", "tenant", tenantID)
+	}
+
+	fmt.Fprintln(out, "Token is valid")
+	return nil
+}
+
+// failedCheck maps a validation error code to the name of the failed check
+func failedCheck(err *errors.RelayError) string {
+	switch err.Code {
+	case errors.ErrInvalidSignature:
+		return "signature"
+	case errors.ErrUnknownKeyID:
+		return "kid"
+	case errors.ErrInvalidIssuer:
+		return "issuer"
+	case errors.ErrInvalidAudience:
+		return "audience"
+	case errors.ErrTokenExpired, errors.ErrTokenNotYetValid:
+		return "expiry"
+	case errors.ErrTokenTooOld:
+		return "age"
+	case errors.ErrMissingClaim:
+		if strings.Contains(err.Message, "tenant_id") {
+			return "tenant"
+		}
+		return "claims"
+	default:
+		return "format"
+	}
+}
+
+// relativeTime describes t relative to now
+func relativeTime(t time.Time) string {
+	d := time.Until(t).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s ago", -d)
+	}
+	return fmt.Sprintf("in %s", d)
+}
@@ -2,8 +2,11 @@ package auth
 
 import (
 	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"net/http"
 	"strings"
 	"time"
@@ -14,29 +17,50 @@ import (
 
 // Claims represents JWT claims with tenant support
 type Claims struct {
-	Subject   string `json:"sub"`
-	TenantID  string `json:"tenant_id,omitempty"`
-	Issuer    string `json:"iss,omitempty"`
-	Audience  string `json:"aud,omitempty"`
-	ExpiresAt int64  `json:"exp,omitempty"`
-	IssuedAt  int64  `json:"iat,omitempty"`
-	NotBefore int64  `json:"nbf,omitempty"`
+	TenantID    string       `json:"tenant_id,omitempty"`
+	TunnelScope *TunnelScope `json:"tunnel_scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// claimsFromMap converts map claims into typed claims
+func claimsFromMap(mapClaims jwt.MapClaims) (*Claims, error) {
+	data, err := json.Marshal(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
 // AuthManager handles authentication with relay server
 type AuthManager struct {
 	config     *AuthConfig
+	policy     *ValidationPolicy
 	jwtSecret  []byte
 	publicKey  *rsa.PublicKey
+	publicKeys map[string]*rsa.PublicKey
 	httpClient *http.Client
 }
 
 // AuthConfig contains authentication configuration
 type AuthConfig struct {
-	Type     string          `json:"type"`
-	Secret   string          `json:"secret"`
-	Keycloak *KeycloakConfig `json:"keycloak,omitempty"`
+	Type       string            `json:"type"`
+	Secret     string            `json:"secret"`
+	Keycloak   *KeycloakConfig   `json:"keycloak,omitempty"`
+	OIDC       *OIDCConfig       `json:"oidc,omitempty"`
+	MTLS       *MTLSConfig       `json:"mtls,omitempty"`
+	Validation *ValidationPolicy `json:"validation,omitempty"`
+}
+
+// OIDCConfig contains generic OpenID Connect provider configuration
+type OIDCConfig struct {
+	IssuerURL string `json:"issuer_url"`
+	JWKSURL   string `json:"jwks_url"`
 }
 
 // KeycloakConfig contains Keycloak-specific configuration
@@ -66,11 +90,17 @@ type JWK struct {
 func NewAuthManager(config *AuthConfig) (*AuthManager, error) {
 	am := &AuthManager{
 		config: config,
+		policy: &ValidationPolicy{},
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 
+	if config.Validation != nil {
+		policy := *config.Validation
+		am.policy = &policy
+	}
+
 	switch config.Type {
 	case "jwt":
 		if config.Secret == "" {
@@ -86,6 +116,22 @@ func NewAuthManager(config *AuthConfig) (*AuthManager, error) {
 			return nil, fmt.Errorf("failed to setup keycloak: %w", err)
 		}
 
+	case "oidc":
+		if config.OIDC == nil || config.OIDC.IssuerURL == "" {
+			return nil, fmt.Errorf("oidc issuer URL is required")
+		}
+		if err := am.setupOIDC(); err != nil {
+			return nil, fmt.Errorf("failed to setup oidc: %w", err)
+		}
+
+	case "mtls":
+		if config.MTLS == nil {
+			return nil, fmt.Errorf("mtls configuration is required")
+		}
+		if err := am.setupMTLS(); err != nil {
+			return nil, fmt.Errorf("failed to setup mtls: %w", err)
+		}
+
 	default:
 		return nil, fmt.Errorf("unsupported authentication type: %s", config.Type)
 	}
@@ -103,30 +149,120 @@ func (am *AuthManager) setupKeycloak() error {
 		)
 	}
 
+	// Keycloak tokens are issued by the realm for the configured client. Access tokens
+	// usually name the client in azp rather than aud, so either claim may match it;
+	// the audience is only enforced when configured explicitly.
+	if am.policy.Issuer == "" {
+		am.policy.Issuer = fmt.Sprintf("%s/realms/%s", am.config.Keycloak.ServerURL, am.config.Keycloak.Realm)
+	}
+	if len(am.policy.Audience) == 0 && am.config.Keycloak.ClientID != "" {
+		am.policy.AuthorizedParty = am.config.Keycloak.ClientID
+	}
+
+	return am.loadPublicKey(am.config.Keycloak.JWKSURL)
+}
+
+// setupOIDC initializes generic OpenID Connect authentication
+func (am *AuthManager) setupOIDC() error {
+	issuer := strings.TrimSuffix(am.config.OIDC.IssuerURL, "/")
+	if am.policy.Issuer == "" {
+		am.policy.Issuer = issuer
+	}
+
+	if am.config.OIDC.JWKSURL == "" {
+		jwksURL, err := am.discoverJWKSURL(issuer)
+		if err != nil {
+			return err
+		}
+		am.config.OIDC.JWKSURL = jwksURL
+	}
+
+	return am.loadPublicKey(am.config.OIDC.JWKSURL)
+}
+
+// discoverJWKSURL reads the jwks_uri from the provider discovery document
+func (am *AuthManager) discoverJWKSURL(issuer string) (string, error) {
+	resp, err := am.httpClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			_ = cerr // Игнорируем ошибку закрытия response body
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch discovery document: %s", resp.Status)
+	}
+
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return "", fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document has no jwks_uri")
+	}
+
+	return discovery.JWKSURI, nil
+}
+
+// loadPublicKey fetches the JWKS and indexes its RSA keys by kid
+func (am *AuthManager) loadPublicKey(jwksURL string) error {
 	// Fetch JWKS
-	jwks, err := am.fetchJWKS()
+	jwks, err := am.fetchJWKS(jwksURL)
 	if err != nil {
 		return fmt.Errorf("failed to fetch jwks: %w", err)
 	}
 
-	// Convert first key to RSA public key
 	if len(jwks.Keys) == 0 {
 		return fmt.Errorf("no keys found in jwks")
 	}
 
-	key := jwks.Keys[0]
-	publicKey, err := am.jwkToRSAPublicKey(key)
-	if err != nil {
-		return fmt.Errorf("failed to convert jwk to RSA public key: %w", err)
+	// Convert keys to RSA public keys, the first one is used for tokens without kid
+	am.publicKeys = make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := am.jwkToRSAPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to convert jwk %s to RSA public key: %w", key.Kid, err)
+		}
+		if am.publicKey == nil {
+			am.publicKey = publicKey
+		}
+		if key.Kid != "" {
+			am.publicKeys[key.Kid] = publicKey
+		}
+	}
+
+	if am.publicKey == nil {
+		return fmt.Errorf("no signing keys found in jwks")
 	}
 
-	am.publicKey = publicKey
 	return nil
 }
 
-// fetchJWKS fetches JSON Web Key Set from Keycloak
-func (am *AuthManager) fetchJWKS() (*JWKS, error) {
-	resp, err := am.httpClient.Get(am.config.Keycloak.JWKSURL)
+// rsaKey selects the verification key for token by its kid header
+func (am *AuthManager) rsaKey(token *jwt.Token) (*rsa.PublicKey, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" || len(am.publicKeys) == 0 {
+		return am.publicKey, nil
+	}
+
+	key, exists := am.publicKeys[kid]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// fetchJWKS fetches JSON Web Key Set from the identity provider
+func (am *AuthManager) fetchJWKS(jwksURL string) (*JWKS, error) {
+	resp, err := am.httpClient.Get(jwksURL)
 	if err != nil {
 		return nil, err
 	}
@@ -150,9 +286,39 @@ func (am *AuthManager) fetchJWKS() (*JWKS, error) {
 
 // jwkToRSAPublicKey converts JWK to RSA public key
 func (am *AuthManager) jwkToRSAPublicKey(jwk JWK) (*rsa.PublicKey, error) {
-	// This is a simplified implementation
-	// In production, you should use a proper JWK library
-	return nil, fmt.Errorf("JWK to RSA conversion not implemented")
+	if jwk.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 || exponent.Int64() < 3 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// Type returns the configured authentication type
+func (am *AuthManager) Type() string {
+	return am.config.Type
+}
+
+// Policy returns the effective validation policy, including the issuer and audience
+// that keycloak and oidc derive from their settings
+func (am *AuthManager) Policy() ValidationPolicy {
+	return *am.policy
 }
 
 // ValidateToken validates a JWT token
@@ -162,6 +328,10 @@ func (am *AuthManager) ValidateToken(tokenString string) (*jwt.Token, error) {
 		return am.validateJWTToken(tokenString)
 	case "keycloak":
 		return am.validateKeycloakToken(tokenString)
+	case "oidc":
+		return am.validateRSAToken("OIDC", tokenString)
+	case "mtls":
+		return nil, fmt.Errorf("token validation is not used with mtls authentication")
 	default:
 		return nil, fmt.Errorf("unsupported authentication type")
 	}
@@ -175,65 +345,55 @@ func (am *AuthManager) validateJWTToken(tokenString string) (*jwt.Token, error)
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return am.jwtSecret, nil
-	})
+	}, am.policy.parserOptions()...)
 
 	if err != nil {
-		return nil, errors.NewRelayError(errors.ErrInvalidToken, fmt.Sprintf("JWT validation failed: %v", err))
+		return nil, classifyParseError("JWT validation failed", err)
 	}
 
 	if !token.Valid {
 		return nil, errors.NewRelayError(errors.ErrInvalidToken, "invalid JWT token")
 	}
 
+	// Validate claims
+	if err := am.policy.validateClaims(token.Claims.(jwt.MapClaims)); err != nil {
+		return nil, err
+	}
+
 	return token, nil
 }
 
 // validateKeycloakToken validates a Keycloak token
 func (am *AuthManager) validateKeycloakToken(tokenString string) (*jwt.Token, error) {
+	return am.validateRSAToken("keycloak", tokenString)
+}
+
+// validateRSAToken validates a token signed by the identity provider's RSA key
+func (am *AuthManager) validateRSAToken(provider, tokenString string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate algorithm
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return am.publicKey, nil
-	})
+		return am.rsaKey(token)
+	}, am.policy.parserOptions()...)
 
 	if err != nil {
-		return nil, errors.NewRelayError(errors.ErrInvalidToken, fmt.Sprintf("keycloak token validation failed: %v", err))
+		return nil, classifyParseError(fmt.Sprintf("%s token validation failed", provider), err)
 	}
 
 	if !token.Valid {
-		return nil, errors.NewRelayError(errors.ErrInvalidToken, "Invalid Keycloak token")
+		return nil, errors.NewRelayError(errors.ErrInvalidToken, fmt.Sprintf("Invalid %s token", provider))
 	}
 
 	// Validate claims
-	if err := am.validateKeycloakClaims(token.Claims); err != nil {
-		return nil, errors.NewRelayError(errors.ErrInvalidToken, fmt.Sprintf("Invalid claims: %v", err))
+	if err := am.policy.validateClaims(token.Claims.(jwt.MapClaims)); err != nil {
+		return nil, err
 	}
 
 	return token, nil
 }
 
-// validateKeycloakClaims validates Keycloak-specific claims
-func (am *AuthManager) validateKeycloakClaims(claims jwt.Claims) error {
-	// Validate issuer
-	if issuer, ok := claims.(jwt.MapClaims)["iss"]; ok {
-		expectedIssuer := fmt.Sprintf("%s/realms/%s", am.config.Keycloak.ServerURL, am.config.Keycloak.Realm)
-		if issuer != expectedIssuer {
-			return fmt.Errorf("invalid issuer: expected %s, got %s", expectedIssuer, issuer)
-		}
-	}
-
-	// Validate audience
-	if aud, ok := claims.(jwt.MapClaims)["aud"]; ok {
-		if aud != am.config.Keycloak.ClientID {
-			return fmt.Errorf("invalid audience: expected %s, got %s", am.config.Keycloak.ClientID, aud)
-		}
-	}
-
-	return nil
-}
-
 // ExtractSubject extracts subject from token
 func (am *AuthManager) ExtractSubject(token *jwt.Token) (string, error) {
 	claims, ok := token.Claims.(jwt.MapClaims)
@@ -1,9 +1,17 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -178,3 +186,148 @@ func TestExtractClaims(t *testing.T) {
 		t.Errorf("Expected tenant_id 'tenant-001', got '%s'", tenantID)
 	}
 }
+
+func TestValidationPolicy(t *testing.T) {
+	secret := "testsecret"
+	now := time.Now()
+	policy := &ValidationPolicy{
+		Issuer:         "https://issuer.example.com",
+		Audience:       []string{"relay", "relay-staging"},
+		RequiredClaims: []string{"tenant_id"},
+		Leeway:         30 * time.Second,
+		MaxTokenAge:    time.Hour,
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":       "user1",
+			"iss":       "https://issuer.example.com",
+			"aud":       []string{"relay"},
+			"tenant_id": "tenant-001",
+			"iat":       now.Unix(),
+			"exp":       now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		code   string
+	}{
+		{"valid", func(c jwt.MapClaims) {}, ""},
+		{"expired within leeway", func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }, ""},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, errors.ErrTokenExpired},
+		{"not yet valid", func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }, errors.ErrTokenNotYetValid},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" }, errors.ErrInvalidIssuer},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other" }, errors.ErrInvalidAudience},
+		{"missing tenant", func(c jwt.MapClaims) { delete(c, "tenant_id") }, errors.ErrMissingClaim},
+		{"too old", func(c jwt.MapClaims) { c["iat"] = now.Add(-2 * time.Hour).Unix() }, errors.ErrTokenTooOld},
+	}
+
+	am, err := NewAuthManager(&AuthConfig{Type: "jwt", Secret: secret, Validation: policy})
+	if err != nil {
+		t.Fatalf("failed to create auth manager: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.mutate(claims)
+			tokenStr, err := generateJWT(secret, claims)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+
+			_, err = am.ValidateToken(tokenStr)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("expected valid token, got error: %v", err)
+				}
+				return
+			}
+
+			relayErr, ok := err.(*errors.RelayError)
+			if !ok {
+				t.Fatalf("expected relay error %s, got %v", tt.code, err)
+			}
+			if relayErr.Code != tt.code {
+				t.Errorf("expected error code %s, got %s", tt.code, relayErr.Code)
+			}
+		})
+	}
+}
+
+func TestKeycloakClientCheck(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer server.Close()
+
+	newManager := func(audience []string) *AuthManager {
+		am, err := NewAuthManager(&AuthConfig{
+			Type:       "keycloak",
+			Keycloak:   &KeycloakConfig{ServerURL: "https://sso.example.com", Realm: "relay", ClientID: "relay-client", JWKSURL: server.URL},
+			Validation: &ValidationPolicy{Audience: audience},
+		})
+		if err != nil {
+			t.Fatalf("failed to create auth manager: %v", err)
+		}
+		return am
+	}
+	sign := func(aud interface{}, azp string) string {
+		claims := jwt.MapClaims{
+			"sub": "user1",
+			"iss": "https://sso.example.com/realms/relay",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if aud != nil {
+			claims["aud"] = aud
+		}
+		if azp != "" {
+			claims["azp"] = azp
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name     string
+		audience []string
+		token    string
+		valid    bool
+	}{
+		{"client in azp", nil, sign("account", "relay-client"), true},
+		{"client in aud", nil, sign([]string{"relay-client"}, ""), true},
+		{"no audience", nil, sign(nil, "relay-client"), true},
+		{"other client", nil, sign("account", "other-client"), false},
+		{"explicit audience", []string{"account"}, sign("account", "other-client"), true},
+		{"explicit audience mismatch", []string{"relay"}, sign("account", "relay-client"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newManager(tt.audience).ValidateToken(tt.token)
+			if tt.valid && err != nil {
+				t.Errorf("expected valid token, got error: %v", err)
+			}
+			if !tt.valid {
+				if relayErr, ok := err.(*errors.RelayError); !ok || relayErr.Code != errors.ErrInvalidAudience {
+					t.Errorf("expected %s, got %v", errors.ErrInvalidAudience, err)
+				}
+			}
+		})
+	}
+}
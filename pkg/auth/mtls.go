@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Certificate identity sources for mTLS authentication
+const (
+	IdentitySourceCN       = "cn"
+	IdentitySourceOU       = "ou"
+	IdentitySourceO        = "o"
+	IdentitySourceSANURI   = "san_uri"
+	IdentitySourceSANDNS   = "san_dns"
+	IdentitySourceSANEmail = "san_email"
+	IdentitySourceNone     = "none"
+)
+
+// MTLSConfig contains client certificate authentication configuration
+type MTLSConfig struct {
+	CertFile      string `json:"cert_file"`
+	SubjectSource string `json:"subject_source,omitempty"`
+	TenantSource  string `json:"tenant_source,omitempty"`
+}
+
+// CertificateIdentity is the identity derived from a client certificate
+type CertificateIdentity struct {
+	Subject     string
+	TenantID    string
+	Fingerprint string
+}
+
+// setupMTLS validates the mTLS configuration
+func (am *AuthManager) setupMTLS() error {
+	cfg := am.config.MTLS
+	if cfg.SubjectSource == "" {
+		cfg.SubjectSource = IdentitySourceCN
+	}
+	if cfg.TenantSource == "" {
+		cfg.TenantSource = IdentitySourceOU
+	}
+
+	switch cfg.SubjectSource {
+	case IdentitySourceCN, IdentitySourceSANURI, IdentitySourceSANDNS, IdentitySourceSANEmail:
+	default:
+		return fmt.Errorf("unsupported subject source: %s", cfg.SubjectSource)
+	}
+
+	switch cfg.TenantSource {
+	case IdentitySourceOU, IdentitySourceO, IdentitySourceSANURI, IdentitySourceNone:
+	default:
+		return fmt.Errorf("unsupported tenant source: %s", cfg.TenantSource)
+	}
+
+	// Fail early if the certificate cannot be mapped to an identity
+	_, err := am.CertificateIdentity()
+	return err
+}
+
+// CertificateIdentity reads the configured client certificate file and derives subject and
+// tenant from it. Connected clients use IdentityFromCertificate with the certificate they
+// presented, which may differ from the file after a rotation.
+func (am *AuthManager) CertificateIdentity() (*CertificateIdentity, error) {
+	if am.config.MTLS == nil || am.config.MTLS.CertFile == "" {
+		return nil, fmt.Errorf("client certificate is required for mtls authentication")
+	}
+
+	data, err := os.ReadFile(am.config.MTLS.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", am.config.MTLS.CertFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	return am.IdentityFromCertificate(cert)
+}
+
+// IdentityFromCertificate derives subject and tenant from cert using the configured sources
+func (am *AuthManager) IdentityFromCertificate(cert *x509.Certificate) (*CertificateIdentity, error) {
+	if am.config.MTLS == nil {
+		return nil, fmt.Errorf("client certificate is required for mtls authentication")
+	}
+
+	subject := certificateField(cert, am.config.MTLS.SubjectSource, false)
+	if subject == "" {
+		return nil, fmt.Errorf("client certificate has no %s for subject", am.config.MTLS.SubjectSource)
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	return &CertificateIdentity{
+		Subject:     subject,
+		TenantID:    certificateField(cert, am.config.MTLS.TenantSource, true),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}, nil
+}
+
+// CreateCertificateAuthMessage creates an authentication message referencing the client certificate
+func (am *AuthManager) CreateCertificateAuthMessage(identity *CertificateIdentity) map[string]interface{} {
+	msg := map[string]interface{}{
+		"type":             "auth",
+		"method":           "mtls",
+		"sub":              identity.Subject,
+		"cert_fingerprint": identity.Fingerprint,
+	}
+	if identity.TenantID != "" {
+		msg["tenant_id"] = identity.TenantID
+	}
+	return msg
+}
+
+// certificateField extracts the value named by source from the certificate.
+// For tenants a SAN URI maps to its host, e.g. spiffe://tenant-001/client.
+func certificateField(cert *x509.Certificate, source string, tenant bool) string {
+	switch source {
+	case IdentitySourceCN:
+		return cert.Subject.CommonName
+	case IdentitySourceOU:
+		if len(cert.Subject.OrganizationalUnit) > 0 {
+			return cert.Subject.OrganizationalUnit[0]
+		}
+	case IdentitySourceO:
+		if len(cert.Subject.Organization) > 0 {
+			return cert.Subject.Organization[0]
+		}
+	case IdentitySourceSANURI:
+		if len(cert.URIs) > 0 {
+			if tenant {
+				return cert.URIs[0].Host
+			}
+			return cert.URIs[0].String()
+		}
+	case IdentitySourceSANDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case IdentitySourceSANEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return strings.ToLower(cert.EmailAddresses[0])
+		}
+	}
+	return ""
+}
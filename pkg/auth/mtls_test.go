@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://tenant-001/branch/office-7")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "office-7",
+			OrganizationalUnit: []string{"tenant-ou"},
+		},
+		URIs:      []*url.URL{spiffe},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "client.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	return path
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	certFile := writeTestCertificate(t)
+
+	tests := []struct {
+		subjectSource string
+		tenantSource  string
+		subject       string
+		tenant        string
+	}{
+		{"", "", "office-7", "tenant-ou"},
+		{IdentitySourceSANURI, IdentitySourceSANURI, "spiffe://tenant-001/branch/office-7", "tenant-001"},
+		{IdentitySourceCN, IdentitySourceNone, "office-7", ""},
+	}
+
+	for _, tt := range tests {
+		am, err := NewAuthManager(&AuthConfig{
+			Type: "mtls",
+			MTLS: &MTLSConfig{CertFile: certFile, SubjectSource: tt.subjectSource, TenantSource: tt.tenantSource},
+		})
+		if err != nil {
+			t.Fatalf("failed to create auth manager: %v", err)
+		}
+
+		identity, err := am.CertificateIdentity()
+		if err != nil {
+			t.Fatalf("failed to derive identity: %v", err)
+		}
+		if identity.Subject != tt.subject || identity.TenantID != tt.tenant {
+			t.Errorf("expected %s/%s, got %s/%s", tt.subject, tt.tenant, identity.Subject, identity.TenantID)
+		}
+	}
+
+	if _, err := NewAuthManager(&AuthConfig{
+		Type: "mtls",
+		MTLS: &MTLSConfig{CertFile: certFile, SubjectSource: IdentitySourceSANDNS},
+	}); err == nil {
+		t.Error("expected error for certificate without DNS SAN, got nil")
+	}
+}
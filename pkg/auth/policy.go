@@ -0,0 +1,107 @@
+package auth
+
+import (
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errUnknownKeyID is returned when no JWKS key matches the token kid
+var errUnknownKeyID = stderrors.New("unknown key id")
+
+// ValidationPolicy defines the claim checks applied to every validated token
+type ValidationPolicy struct {
+	Issuer         string        `json:"issuer,omitempty"`
+	Audience       []string      `json:"audience,omitempty"`
+	RequiredClaims []string      `json:"required_claims,omitempty"`
+	Leeway         time.Duration `json:"leeway,omitempty"`
+	MaxTokenAge    time.Duration `json:"max_token_age,omitempty"`
+	// AuthorizedParty is the client a token must name in its aud or azp claim
+	AuthorizedParty string `json:"authorized_party,omitempty"`
+}
+
+// parserOptions returns the jwt parser options for the policy
+func (p *ValidationPolicy) parserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithLeeway(p.Leeway),
+		jwt.WithIssuedAt(),
+	}
+}
+
+// validateClaims applies the issuer, audience, required claim and age checks
+func (p *ValidationPolicy) validateClaims(mapClaims jwt.MapClaims) error {
+	claims, err := claimsFromMap(mapClaims)
+	if err != nil {
+		return errors.NewRelayError(errors.ErrInvalidToken, fmt.Sprintf("invalid claims: %v", err))
+	}
+
+	if p.Issuer != "" && claims.Issuer != p.Issuer {
+		return errors.NewRelayError(errors.ErrInvalidIssuer,
+			fmt.Sprintf("invalid issuer: expected %s, got %s", p.Issuer, claims.Issuer))
+	}
+
+	if len(p.Audience) > 0 && !audienceMatches(claims.Audience, p.Audience) {
+		return errors.NewRelayError(errors.ErrInvalidAudience,
+			fmt.Sprintf("invalid audience: expected one of %v, got %v", p.Audience, []string(claims.Audience)))
+	}
+
+	if p.AuthorizedParty != "" {
+		azp, _ := mapClaims["azp"].(string)
+		if azp != p.AuthorizedParty && !audienceMatches(claims.Audience, []string{p.AuthorizedParty}) {
+			return errors.NewRelayError(errors.ErrInvalidAudience,
+				fmt.Sprintf("token is not issued for client %s: azp %q, audience %v", p.AuthorizedParty, azp, []string(claims.Audience)))
+		}
+	}
+
+	for _, name := range p.RequiredClaims {
+		if value, ok := mapClaims[name]; !ok || value == nil || value == "" {
+			return errors.NewRelayError(errors.ErrMissingClaim,
+				fmt.Sprintf("required claim %s is missing", name))
+		}
+	}
+
+	if p.MaxTokenAge > 0 {
+		if claims.IssuedAt == nil {
+			return errors.NewRelayError(errors.ErrMissingClaim,
+				"required claim iat is missing for max token age check")
+		}
+		if age := time.Since(claims.IssuedAt.Time); age > p.MaxTokenAge+p.Leeway {
+			return errors.NewRelayError(errors.ErrTokenTooOld,
+				fmt.Sprintf("token issued %s ago exceeds maximum age %s", age.Round(time.Second), p.MaxTokenAge))
+		}
+	}
+
+	return nil
+}
+
+// audienceMatches reports whether any token audience is in the allowed list
+func audienceMatches(tokenAudience jwt.ClaimStrings, allowed []string) bool {
+	for _, aud := range tokenAudience {
+		for _, expected := range allowed {
+			if aud == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classifyParseError maps jwt parser errors to relay error codes
+func classifyParseError(prefix string, err error) *errors.RelayError {
+	code := errors.ErrInvalidToken
+	switch {
+	case stderrors.Is(err, errUnknownKeyID):
+		code = errors.ErrUnknownKeyID
+	case stderrors.Is(err, jwt.ErrTokenExpired):
+		code = errors.ErrTokenExpired
+	case stderrors.Is(err, jwt.ErrTokenNotValidYet), stderrors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		code = errors.ErrTokenNotYetValid
+	case stderrors.Is(err, jwt.ErrTokenSignatureInvalid), stderrors.Is(err, jwt.ErrTokenUnverifiable):
+		code = errors.ErrInvalidSignature
+	}
+
+	return errors.NewRelayError(code, fmt.Sprintf("%s: %v", prefix, err))
+}
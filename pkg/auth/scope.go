@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TunnelScopeClaim is the claim carrying tunnel permissions
+const TunnelScopeClaim = "tunnel_scope"
+
+// TunnelScope restricts which tunnels a token may create.
+// Targets are "host:port" patterns where host is a glob (e.g. "10.0.0.*")
+// and port is a number, a range ("5000-5010") or "*". LocalPorts holds
+// port numbers or ranges; Unix socket tunnels have no local port and are only
+// checked against the targets. An empty list allows everything for that field.
+type TunnelScope struct {
+	AllowedTargets []string `json:"allowed_targets,omitempty"`
+	LocalPorts     []string `json:"local_ports,omitempty"`
+}
+
+// ExtractTunnelScope extracts the tunnel scope from token, returning nil if the claim is absent
+func (am *AuthManager) ExtractTunnelScope(token *jwt.Token) (*TunnelScope, error) {
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	if _, ok := mapClaims[TunnelScopeClaim]; !ok {
+		return nil, nil
+	}
+
+	claims, err := claimsFromMap(mapClaims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s claim: %w", TunnelScopeClaim, err)
+	}
+
+	if err := claims.TunnelScope.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s claim: %w", TunnelScopeClaim, err)
+	}
+
+	return claims.TunnelScope, nil
+}
+
+// Allows checks a tunnel request against the scope
+func (s *TunnelScope) Allows(localPort int, remoteHost string, remotePort int) error {
+	if s == nil {
+		return nil
+	}
+
+	if localPort > 0 && len(s.LocalPorts) > 0 && !matchesAnyPort(s.LocalPorts, localPort) {
+		return errors.NewRelayError(errors.ErrTunnelNotPermitted,
+			fmt.Sprintf("local port %d is not permitted by token scope %v", localPort, s.LocalPorts))
+	}
+
+	if len(s.AllowedTargets) > 0 && !s.allowsTarget(remoteHost, remotePort) {
+		return errors.NewRelayError(errors.ErrTunnelNotPermitted,
+			fmt.Sprintf("remote target %s is not permitted by token scope %v",
+				net.JoinHostPort(remoteHost, strconv.Itoa(remotePort)), s.AllowedTargets))
+	}
+
+	return nil
+}
+
+// allowsTarget reports whether any target pattern matches host and port
+func (s *TunnelScope) allowsTarget(host string, port int) bool {
+	host = strings.ToLower(host)
+	for _, target := range s.AllowedTargets {
+		hostPattern, portPattern, err := net.SplitHostPort(target)
+		if err != nil {
+			continue
+		}
+		if matched, _ := path.Match(strings.ToLower(hostPattern), host); !matched {
+			continue
+		}
+		if matchesPort(portPattern, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that every pattern in the scope is well formed
+func (s *TunnelScope) validate() error {
+	if s == nil {
+		return nil
+	}
+
+	for _, target := range s.AllowedTargets {
+		hostPattern, portPattern, err := net.SplitHostPort(target)
+		if err != nil {
+			return fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if _, err := path.Match(hostPattern, ""); err != nil {
+			return fmt.Errorf("invalid host pattern %q: %w", hostPattern, err)
+		}
+		if _, _, err := parsePortRange(portPattern); err != nil {
+			return fmt.Errorf("invalid target %q: %w", target, err)
+		}
+	}
+
+	for _, ports := range s.LocalPorts {
+		if _, _, err := parsePortRange(ports); err != nil {
+			return fmt.Errorf("invalid local ports %q: %w", ports, err)
+		}
+	}
+
+	return nil
+}
+
+// matchesAnyPort reports whether port matches any of the patterns
+func matchesAnyPort(patterns []string, port int) bool {
+	for _, pattern := range patterns {
+		if matchesPort(pattern, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPort reports whether port matches a single port pattern
+func matchesPort(pattern string, port int) bool {
+	low, high, err := parsePortRange(pattern)
+	if err != nil {
+		return false
+	}
+	return port >= low && port <= high
+}
+
+// parsePortRange parses "*", "N" or "N-M" into an inclusive range
+func parsePortRange(pattern string) (int, int, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "*" {
+		return 1, 65535, nil
+	}
+
+	lowStr, highStr, isRange := strings.Cut(pattern, "-")
+	low, err := strconv.Atoi(lowStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", pattern)
+	}
+	high := low
+	if isRange {
+		if high, err = strconv.Atoi(highStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", pattern)
+		}
+	}
+
+	if low <= 0 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", pattern)
+	}
+
+	return low, high, nil
+}
@@ -0,0 +1,421 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// credentialStoreVersion is the on-disk format version of the store
+	credentialStoreVersion = 1
+	// credentialStoreKDF identifies the key derivation function
+	credentialStoreKDF = "pbkdf2-sha256"
+	// credentialStoreIterations is the default PBKDF2 iteration count
+	credentialStoreIterations = 600000
+	// credentialStoreMinIterations and credentialStoreMaxIterations bound the iteration count
+	// read from a store, so a tampered file can neither weaken the key nor stall the client
+	credentialStoreMinIterations = 100000
+	credentialStoreMaxIterations = 10000000
+	// credentialStoreSaltSize is the size of the per-store salt in bytes
+	credentialStoreSaltSize = 16
+	// credentialStoreKeySize is the size of the derived AES-256 key
+	credentialStoreKeySize = 32
+
+	// CredentialPassphraseEnv is the environment variable holding the store passphrase
+	CredentialPassphraseEnv = "CLOUDBRIDGE_CREDENTIAL_PASSPHRASE"
+)
+
+// Credential represents cached tokens for a single identity
+type Credential struct {
+	Identity     string    `json:"identity"`
+	AccessToken  string    `json:"access_token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+}
+
+// NewCredentialFromToken creates a credential for identity, taking the expiry from the token's exp claim
+func NewCredentialFromToken(identity, accessToken string) *Credential {
+	cred := &Credential{
+		Identity:    identity,
+		AccessToken: accessToken,
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err == nil {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			cred.ExpiresAt = exp.Time
+		}
+	}
+
+	return cred
+}
+
+// Expired reports whether the cached access token has expired
+func (c *Credential) Expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+// CredentialInfo describes a stored identity without exposing its secrets
+type CredentialInfo struct {
+	Identity  string
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+// credentialFile is the on-disk representation of the store
+type credentialFile struct {
+	Version    int                         `json:"version"`
+	KDF        string                      `json:"kdf"`
+	Iterations int                         `json:"iterations"`
+	Salt       string                      `json:"salt"`
+	Entries    map[string]*credentialEntry `json:"entries"`
+}
+
+// credentialEntry is a single encrypted credential
+type credentialEntry struct {
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CredentialStore keeps credentials encrypted with AES-GCM on disk
+type CredentialStore struct {
+	path string
+	aead cipher.AEAD
+	file *credentialFile
+	mu   sync.Mutex
+}
+
+// DefaultCredentialStorePath returns the default location of the credential store
+func DefaultCredentialStorePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".cloudbridge-client", "credentials.json"), nil
+}
+
+// LoadStoreSecret returns the secret used to derive the store key.
+// The key file takes precedence over the passphrase environment variable.
+func LoadStoreSecret(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		if err := checkPrivatePermissions(keyFile); err != nil {
+			return nil, err
+		}
+		secret, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", keyFile)
+		}
+		return secret, nil
+	}
+
+	if passphrase := os.Getenv(CredentialPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	return nil, fmt.Errorf("no credential store key file or %s passphrase provided", CredentialPassphraseEnv)
+}
+
+// OpenCredentialStore opens the store at path, creating it if it does not exist
+func OpenCredentialStore(path string, secret []byte) (*CredentialStore, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("credential store secret is required")
+	}
+
+	file, err := readCredentialFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential store salt: %w", err)
+	}
+
+	key := pbkdf2SHA256(secret, salt, file.Iterations, credentialStoreKeySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	store := &CredentialStore{
+		path: path,
+		aead: aead,
+		file: file,
+	}
+
+	// Verify the key against an existing entry so a wrong passphrase fails early
+	for identity := range file.Entries {
+		if _, err := store.decrypt(identity); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	return store, nil
+}
+
+// Save encrypts and stores a credential, replacing any existing entry
+func (s *CredentialStore) Save(cred *Credential) error {
+	if cred == nil || cred.Identity == "" {
+		return fmt.Errorf("credential identity is required")
+	}
+
+	plaintext, err := json.Marshal(cred)
+	if err != nil {
+		return fmt.Errorf("failed to encode credential: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	ciphertext := s.aead.Seal(nil, nonce, plaintext, []byte(cred.Identity))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.file.Entries[cred.Identity] = &credentialEntry{
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		ExpiresAt:  cred.ExpiresAt,
+		UpdatedAt:  time.Now().UTC(),
+	}
+
+	return writeCredentialFile(s.path, s.file)
+}
+
+// Load returns the decrypted credential for identity
+func (s *CredentialStore) Load(identity string) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.decrypt(identity)
+}
+
+// List returns the stored identities sorted by name
+func (s *CredentialStore) List() []CredentialInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]CredentialInfo, 0, len(s.file.Entries))
+	for identity, entry := range s.file.Entries {
+		infos = append(infos, CredentialInfo{
+			Identity:  identity,
+			ExpiresAt: entry.ExpiresAt,
+			UpdatedAt: entry.UpdatedAt,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Identity < infos[j].Identity
+	})
+
+	return infos
+}
+
+// Revoke removes the credential for identity from the store
+func (s *CredentialStore) Revoke(identity string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.file.Entries[identity]; !exists {
+		return fmt.Errorf("identity %s not found", identity)
+	}
+	delete(s.file.Entries, identity)
+
+	return writeCredentialFile(s.path, s.file)
+}
+
+// decrypt decrypts a single entry, callers must hold s.mu
+func (s *CredentialStore) decrypt(identity string) (*Credential, error) {
+	entry, exists := s.file.Entries[identity]
+	if !exists {
+		return nil, fmt.Errorf("identity %s not found", identity)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(entry.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce for identity %s: %w", identity, err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(entry.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext for identity %s: %w", identity, err)
+	}
+
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(identity))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential store: wrong key or corrupted data")
+	}
+
+	var cred Credential
+	if err := json.Unmarshal(plaintext, &cred); err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	return &cred, nil
+}
+
+// readCredentialFile reads the store file or initializes a new one
+func readCredentialFile(path string) (*credentialFile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		salt := make([]byte, credentialStoreSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		return &credentialFile{
+			Version:    credentialStoreVersion,
+			KDF:        credentialStoreKDF,
+			Iterations: credentialStoreIterations,
+			Salt:       base64.StdEncoding.EncodeToString(salt),
+			Entries:    make(map[string]*credentialEntry),
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential store: %w", err)
+	}
+
+	if err := checkPrivatePermissions(path); err != nil {
+		return nil, err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode credential store: %w", err)
+	}
+
+	if file.Version != credentialStoreVersion {
+		return nil, fmt.Errorf("unsupported credential store version: %d", file.Version)
+	}
+	if file.KDF != credentialStoreKDF {
+		return nil, fmt.Errorf("unsupported credential store kdf: %s", file.KDF)
+	}
+	if file.Iterations < credentialStoreMinIterations || file.Iterations > credentialStoreMaxIterations {
+		return nil, fmt.Errorf("credential store kdf iterations %d out of range %d-%d",
+			file.Iterations, credentialStoreMinIterations, credentialStoreMaxIterations)
+	}
+	if file.Entries == nil {
+		file.Entries = make(map[string]*credentialEntry)
+	}
+
+	return &file, nil
+}
+
+// writeCredentialFile atomically writes the store with 0600 permissions
+func writeCredentialFile(path string, file *credentialFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credential store: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create credential store directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if err := os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+			_ = err // Временный файл уже переименован или удален
+		}
+	}()
+
+	if err := tmp.Chmod(0600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to set credential store permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync credential store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close credential store: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace credential store: %w", err)
+	}
+
+	return nil
+}
+
+// checkPrivatePermissions rejects files readable by group or others
+func checkPrivatePermissions(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("insecure permissions %#o on %s, expected 0600", perm, path)
+	}
+
+	return nil
+}
+
+// pbkdf2SHA256 derives a key from password and salt as defined in RFC 8018
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		key = prf.Sum(key)
+
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+
+	return key[:keyLen]
+}
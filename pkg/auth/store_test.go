@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCredentialStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := OpenCredentialStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	cred := &Credential{
+		Identity:     "site-a",
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresAt:    time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	if err := store.Save(cred); err != nil {
+		t.Fatalf("failed to save credential: %v", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat store: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("expected 0600 permissions, got %#o", perm)
+		}
+	}
+
+	reopened, err := OpenCredentialStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	loaded, err := reopened.Load("site-a")
+	if err != nil {
+		t.Fatalf("failed to load credential: %v", err)
+	}
+	if loaded.AccessToken != "access" || loaded.RefreshToken != "refresh" {
+		t.Errorf("unexpected credential: %+v", loaded)
+	}
+	if !loaded.ExpiresAt.Equal(cred.ExpiresAt) {
+		t.Errorf("expected expiry %v, got %v", cred.ExpiresAt, loaded.ExpiresAt)
+	}
+
+	if _, err := OpenCredentialStore(path, []byte("wrong")); err == nil {
+		t.Error("expected error for wrong passphrase, got nil")
+	}
+
+	if err := reopened.Revoke("site-a"); err != nil {
+		t.Fatalf("failed to revoke credential: %v", err)
+	}
+	if len(reopened.List()) != 0 {
+		t.Errorf("expected empty store after revoke, got %v", reopened.List())
+	}
+}
+
+func TestCredentialStoreInsecurePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not enforced on windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := OpenCredentialStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if err := store.Save(&Credential{Identity: "site-a", AccessToken: "access"}); err != nil {
+		t.Fatalf("failed to save credential: %v", err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("failed to chmod store: %v", err)
+	}
+
+	if _, err := OpenCredentialStore(path, []byte("passphrase")); err == nil {
+		t.Error("expected error for world-readable store, got nil")
+	}
+}
+
+func TestCredentialStoreIterationsRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store, err := OpenCredentialStore(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	if err := store.Save(&Credential{Identity: "site-a", AccessToken: "access"}); err != nil {
+		t.Fatalf("failed to save credential: %v", err)
+	}
+
+	for _, iterations := range []int{0, 1, credentialStoreMinIterations - 1, credentialStoreMaxIterations + 1} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read store: %v", err)
+		}
+		var file map[string]interface{}
+		if err := json.Unmarshal(data, &file); err != nil {
+			t.Fatalf("failed to decode store: %v", err)
+		}
+		file["iterations"] = iterations
+		data, err = json.Marshal(file)
+		if err != nil {
+			t.Fatalf("failed to encode store: %v", err)
+		}
+		tampered := filepath.Join(t.TempDir(), "credentials.json")
+		if err := os.WriteFile(tampered, data, 0600); err != nil {
+			t.Fatalf("failed to write store: %v", err)
+		}
+
+		if _, err := OpenCredentialStore(tampered, []byte("passphrase")); err == nil {
+			t.Errorf("expected error for %d iterations, got nil", iterations)
+		}
+	}
+}
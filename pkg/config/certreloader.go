@@ -0,0 +1,250 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certCheckInterval is how often the remaining certificate lifetime is checked
+const certCheckInterval = time.Minute
+
+// CertificateReloader serves the client certificate to TLS handshakes and
+// swaps it when the certificate or key file changes on disk
+type CertificateReloader struct {
+	certFile   string
+	keyFile    string
+	warnBefore time.Duration
+	observer   func(remaining time.Duration)
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	notAfter time.Time
+	warned   bool
+	// loaded identifies the files the current certificate was read from, see fileState
+	loaded string
+
+	watcher  *fsnotify.Watcher
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCertificateReloader loads the key pair and returns a reloader for it.
+// A warning is logged once the certificate expires within warnBefore.
+func NewCertificateReloader(certFile, keyFile string, warnBefore time.Duration) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		warnBefore: warnBefore,
+		done:       make(chan struct{}),
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// SetObserver sets a callback receiving the remaining certificate lifetime on every check
+func (r *CertificateReloader) SetObserver(observer func(remaining time.Duration)) {
+	r.mu.Lock()
+	r.observer = observer
+	r.mu.Unlock()
+
+	r.checkExpiry()
+}
+
+// Start watches the certificate and key files and periodically checks expiry
+func (r *CertificateReloader) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate watcher: %w", err)
+	}
+
+	// Watch the directories so files replaced by rename are picked up as well
+	dirs := map[string]bool{
+		filepath.Dir(r.certFile): true,
+		filepath.Dir(r.keyFile):  true,
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			if cerr := watcher.Close(); cerr != nil {
+				_ = cerr // Игнорируем ошибку закрытия наблюдателя при ошибке запуска
+			}
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	r.watcher = watcher
+
+	go r.run()
+	return nil
+}
+
+// Stop stops watching the certificate files
+func (r *CertificateReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		if r.watcher != nil {
+			if err := r.watcher.Close(); err != nil {
+				_ = err // Игнорируем ошибку закрытия наблюдателя
+			}
+		}
+	})
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Apply makes tlsConfig present the reloaded certificate instead of a static one
+func (r *CertificateReloader) Apply(tlsConfig *tls.Config) {
+	tlsConfig.Certificates = nil
+	tlsConfig.GetClientCertificate = r.GetClientCertificate
+}
+
+// NotAfter returns the expiry time of the current certificate
+func (r *CertificateReloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.notAfter
+}
+
+// Reload loads and validates the key pair and swaps it in.
+// The current certificate is kept when the new pair is invalid.
+func (r *CertificateReloader) Reload() error {
+	state := fileState(r.certFile, r.keyFile)
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("client certificate is not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("client certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.notAfter = leaf.NotAfter
+	r.warned = false
+	r.loaded = state
+	r.mu.Unlock()
+
+	r.checkExpiry()
+	return nil
+}
+
+// run handles file events and expiry checks until the reloader is stopped
+func (r *CertificateReloader) run() {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if !r.changed(event) {
+				continue
+			}
+			// The certificate and key are often written one after the other;
+			// a half-written pair fails validation and is retried on the next event
+			if err := r.Reload(); err != nil {
+				log.Printf("Client certificate reload skipped: %v", err)
+				continue
+			}
+			log.Printf("Client certificate reloaded, valid until %s", r.NotAfter().Format(time.RFC3339))
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Client certificate watcher error: %v", err)
+		case <-ticker.C:
+			r.checkExpiry()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// changed reports whether event may have replaced the certificate or key. Besides writes
+// to the files themselves, any event in their directories counts when the files now resolve
+// to different targets, as when Kubernetes swaps the ..data symlink of a mounted secret.
+func (r *CertificateReloader) changed(event fsnotify.Event) bool {
+	if r.isWatchedFile(event.Name) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+		return true
+	}
+	r.mu.RLock()
+	loaded := r.loaded
+	r.mu.RUnlock()
+	return fileState(r.certFile, r.keyFile) != loaded
+}
+
+// isWatchedFile reports whether name is the certificate or key file
+func (r *CertificateReloader) isWatchedFile(name string) bool {
+	name = filepath.Clean(name)
+	return name == filepath.Clean(r.certFile) || name == filepath.Clean(r.keyFile)
+}
+
+// fileState describes the files paths resolve to after following symlinks, with their size
+// and modification time, so a swapped link target is noticed
+func fileState(paths ...string) string {
+	var state strings.Builder
+	for _, path := range paths {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			fmt.Fprintf(&state, "%s:missing;", path)
+			continue
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			fmt.Fprintf(&state, "%s:missing;", resolved)
+			continue
+		}
+		fmt.Fprintf(&state, "%s:%d:%d;", resolved, info.Size(), info.ModTime().UnixNano())
+	}
+	return state.String()
+}
+
+// checkExpiry reports the remaining lifetime and warns once per certificate
+// when it is about to expire
+func (r *CertificateReloader) checkExpiry() {
+	r.mu.Lock()
+	remaining := time.Until(r.notAfter)
+	observer := r.observer
+	warn := r.warnBefore > 0 && remaining < r.warnBefore && !r.warned
+	if warn {
+		r.warned = true
+	}
+	r.mu.Unlock()
+
+	if observer != nil {
+		observer(remaining)
+	}
+
+	if warn {
+		log.Printf("Warning: client certificate %s expires in %s", r.certFile, remaining.Round(time.Second))
+	}
+}
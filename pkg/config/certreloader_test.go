@@ -0,0 +1,161 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+func writeKeyPair(t *testing.T, certFile, keyFile string, notAfter time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+
+	first := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	writeKeyPair(t, certFile, keyFile, first)
+
+	reloader, err := NewCertificateReloader(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+
+	var remaining time.Duration
+	reloader.SetObserver(func(d time.Duration) { remaining = d })
+	if remaining <= time.Hour {
+		t.Errorf("expected remaining lifetime above 1h, got %v", remaining)
+	}
+
+	second := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	writeKeyPair(t, certFile, keyFile, second)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	cert, _ := reloader.GetClientCertificate(nil)
+	if !cert.Leaf.NotAfter.Equal(second) {
+		t.Errorf("expected reloaded certificate valid until %v, got %v", second, cert.Leaf.NotAfter)
+	}
+
+	// An expired pair is rejected and the current certificate kept
+	writeKeyPair(t, certFile, keyFile, time.Now().Add(-time.Minute))
+	if err := reloader.Reload(); err == nil {
+		t.Error("expected expired certificate to be rejected")
+	}
+	if !reloader.NotAfter().Equal(second) {
+		t.Errorf("expected current certificate to be kept, got expiry %v", reloader.NotAfter())
+	}
+}
+
+func TestCreateTLSConfigWithReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeKeyPair(t, certFile, keyFile, time.Now().Add(time.Hour))
+
+	reloader, err := NewCertificateReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+
+	// Rotation has replaced the key but not yet the certificate
+	writeKeyPair(t, filepath.Join(dir, "next.crt"), keyFile, time.Now().Add(time.Hour))
+
+	cfg := &types.Config{}
+	cfg.Relay.Host = "relay.example.com"
+	cfg.Relay.TLS = types.TLSConfig{Enabled: true, ClientCert: certFile, ClientKey: keyFile}
+	if _, err := CreateTLSConfig(cfg); err == nil {
+		t.Fatal("expected the mismatched pair on disk to fail a static load")
+	}
+	tlsConfig, err := CreateTLSConfigWithReloader(cfg, reloader)
+	if err != nil {
+		t.Fatalf("expected the reloader certificate to be used, got %v", err)
+	}
+	if tlsConfig.GetClientCertificate == nil || len(tlsConfig.Certificates) != 0 {
+		t.Errorf("expected the certificate to be served by the reloader, got %+v", tlsConfig.Certificates)
+	}
+}
+
+func TestCertificateReloaderSymlinkSwap(t *testing.T) {
+	// The layout of a Kubernetes secret volume: files link through a ..data directory link
+	dir := t.TempDir()
+	for _, version := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeKeyPair(t, filepath.Join(dir, "..v1", "tls.crt"), filepath.Join(dir, "..v1", "tls.key"), time.Now().Add(time.Hour))
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloader, err := NewCertificateReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), 0)
+	if err != nil {
+		t.Fatalf("failed to create reloader: %v", err)
+	}
+	if err := reloader.Start(); err != nil {
+		t.Fatalf("failed to start reloader: %v", err)
+	}
+	defer reloader.Stop()
+
+	// Rotate by atomically replacing the ..data link, the watched names never change
+	renewed := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	writeKeyPair(t, filepath.Join(dir, "..v2", "tls.crt"), filepath.Join(dir, "..v2", "tls.key"), renewed)
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !reloader.NotAfter().Equal(renewed) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !reloader.NotAfter().Equal(renewed) {
+		t.Errorf("expected the swapped certificate valid until %v, got %v", renewed, reloader.NotAfter())
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// Check returns every problem in the configuration, including checks against the
+// local environment: referenced files, TLS material and local port conflicts
+func Check(c *types.Config) []Problem {
+	p := problems(Validate(c))
+
+	if c.Relay.TLS.Enabled {
+		checkTLSMaterial(&p, c.Relay.TLS)
+	}
+
+	if c.Auth.CredentialStore.Enabled && c.Auth.CredentialStore.KeyFile != "" {
+		checkFile(&p, "auth.credential_store.key_file", c.Auth.CredentialStore.KeyFile)
+	}
+
+	for i, identity := range c.Identities {
+		if identity.TokenFile != "" {
+			checkFile(&p, fmt.Sprintf("identities[%d].token_file", i), identity.TokenFile)
+		}
+	}
+
+	checkPorts(&p, c)
+
+	return p
+}
+
+// checkFile records a problem when path cannot be read
+func checkFile(p *problems, key, path string) {
+	if _, err := os.Stat(path); err != nil {
+		p.add(key, "cannot read %s: %v", path, err)
+	}
+}
+
+// checkTLSMaterial parses the CA and client certificate files
+func checkTLSMaterial(p *problems, c types.TLSConfig) {
+	if c.CACert != "" {
+		if data, err := os.ReadFile(c.CACert); err == nil {
+			if !x509.NewCertPool().AppendCertsFromPEM(data) {
+				p.add("relay.tls.ca_cert", "no PEM certificates found in %s", c.CACert)
+			}
+		}
+	}
+
+	if c.ClientCert == "" || c.ClientKey == "" {
+		return
+	}
+	checkFile(p, "relay.tls.client_cert", c.ClientCert)
+	checkFile(p, "relay.tls.client_key", c.ClientKey)
+
+	pair, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		p.add("relay.tls.client_cert", "invalid client certificate or key: %v", err)
+		return
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		p.add("relay.tls.client_cert", "invalid client certificate: %v", err)
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		p.add("relay.tls.client_cert", "client certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	case now.Before(leaf.NotBefore):
+		p.add("relay.tls.client_cert", "client certificate is not valid before %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+}
+
+// checkPorts reports tunnel ports that are already bound on this host,
+// shared between identities or taken by the metrics server
+func checkPorts(p *problems, c *types.Config) {
+	type binding struct {
+		list  string
+		bind  string
+		owner string
+	}
+	used := make(map[int][]binding)
+
+	if c.Metrics.Enabled && c.Metrics.PrometheusPort > 0 {
+		used[c.Metrics.PrometheusPort] = []binding{{list: "metrics", owner: "the metrics server"}}
+	}
+
+	check := func(list, path string, t types.TunnelConfig) {
+		if !t.IsEnabled() {
+			return
+		}
+		if t.IsUnixSocket() {
+			if dir := filepath.Dir(t.SocketPath()); filepath.IsAbs(dir) {
+				if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+					p.add(path+".bind", "socket directory %s does not exist", dir)
+				}
+			}
+			return
+		}
+		if t.LocalPort <= 0 || t.LocalPort > 65535 {
+			return
+		}
+
+		bind := t.BindHost()
+		for _, other := range used[t.LocalPort] {
+			if !types.BindsOverlap(other.bind, bind) {
+				continue
+			}
+			// Duplicates within one list are already reported by Validate
+			if other.list == list && other.bind == bind {
+				return
+			}
+			p.add(path+".local_port", "port %d is also used by %s", t.LocalPort, other.owner)
+			return
+		}
+		used[t.LocalPort] = append(used[t.LocalPort], binding{list: list, bind: bind, owner: "tunnel " + t.ID})
+
+		address := t.ListenAddress()
+		ln, err := net.Listen("tcp", address)
+		if err != nil {
+			p.add(path+".local_port", "cannot listen on %s: %v", address, err)
+			return
+		}
+		if err := ln.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия при проверке порта
+		}
+	}
+
+	for i, t := range c.Tunnels {
+		check("tunnels", fmt.Sprintf("tunnels[%d]", i), t)
+	}
+	for i, identity := range c.Identities {
+		list := fmt.Sprintf("identities[%d].tunnels", i)
+		for j, t := range identity.Tunnels {
+			check(list, fmt.Sprintf("%s[%d]", list, j), t)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log"
 	"os"
 
 	"github.com/2gc-dev/cloudbridge-client/pkg/types"
@@ -12,132 +13,64 @@ import (
 
 // LoadConfig loads configuration from file and environment variables
 func LoadConfig(configPath string) (*types.Config, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("/etc/cloudbridge-client")
-	viper.AddConfigPath("$HOME/.cloudbridge-client")
-
-	// Set defaults
-	setDefaults()
-
-	// Read config file if specified
-	if configPath != "" {
-		viper.SetConfigFile(configPath)
-	}
-
-	// Read environment variables
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("CLOUDBRIDGE")
-
-	// Read config
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("failed to read config: %w", err)
-		}
-	}
-
-	var config types.Config
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
-	}
-
-	// Validate configuration
-	if err := validateConfig(&config); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
-	}
-
-	return &config, nil
+	return NewLoader(configPath).Load()
 }
 
 // setDefaults sets default configuration values
-func setDefaults() {
-	viper.SetDefault("relay.host", "edge.2gc.ru")
-	viper.SetDefault("relay.port", 8080)
-	viper.SetDefault("relay.timeout", "30s")
-	viper.SetDefault("relay.tls.enabled", true)
-	viper.SetDefault("relay.tls.min_version", "1.3")
-	viper.SetDefault("relay.tls.verify_cert", true)
-	viper.SetDefault("auth.type", "jwt")
-	viper.SetDefault("auth.keycloak.enabled", false)
-	viper.SetDefault("rate_limiting.enabled", true)
-	viper.SetDefault("rate_limiting.max_retries", 3)
-	viper.SetDefault("rate_limiting.backoff_multiplier", 2.0)
-	viper.SetDefault("rate_limiting.max_backoff", "30s")
-	viper.SetDefault("logging.level", "info")
-	viper.SetDefault("logging.format", "json")
-	viper.SetDefault("logging.output", "stdout")
-}
-
-// validateConfig validates the configuration
-func validateConfig(c *types.Config) error {
-	if c.Relay.Host == "" {
-		return fmt.Errorf("relay host is required")
-	}
-
-	if c.Relay.Port <= 0 || c.Relay.Port > 65535 {
-		return fmt.Errorf("invalid relay port")
-	}
-
-	if c.Relay.TLS.Enabled && c.Relay.TLS.MinVersion != "1.3" {
-		return fmt.Errorf("only TLS 1.3 is supported")
-	}
-
-	if c.Relay.TLS.Enabled && c.Relay.TLS.CACert != "" {
-		if _, err := os.Stat(c.Relay.TLS.CACert); os.IsNotExist(err) {
-			return fmt.Errorf("CA certificate file not found: %s", c.Relay.TLS.CACert)
-		}
-	}
-
-	if c.Relay.TLS.Enabled && c.Relay.TLS.ClientCert != "" && c.Relay.TLS.ClientKey == "" {
-		return fmt.Errorf("client key is required when client certificate is provided")
-	}
-
-	if c.Relay.TLS.Enabled && c.Relay.TLS.ClientKey != "" && c.Relay.TLS.ClientCert == "" {
-		return fmt.Errorf("client certificate is required when client key is provided")
-	}
-
-	if c.Auth.Type == "jwt" && c.Auth.Secret == "" {
-		return fmt.Errorf("JWT secret is required for JWT authentication")
-	}
-
-	if c.Auth.Keycloak.Enabled {
-		if c.Auth.Keycloak.ServerURL == "" {
-			return fmt.Errorf("keycloak server URL is required")
-		}
-		if c.Auth.Keycloak.Realm == "" {
-			return fmt.Errorf("keycloak realm is required")
-		}
-		if c.Auth.Keycloak.ClientID == "" {
-			return fmt.Errorf("keycloak client ID is required")
-		}
-	}
-
-	if c.RateLimiting.MaxRetries < 0 {
-		return fmt.Errorf("max retries cannot be negative")
-	}
-
-	if c.RateLimiting.BackoffMultiplier <= 0 {
-		return fmt.Errorf("backoff multiplier must be positive")
-	}
-
-	return nil
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("relay.host", "edge.2gc.ru")
+	v.SetDefault("relay.port", 8080)
+	v.SetDefault("relay.timeout", "30s")
+	v.SetDefault("relay.tls.enabled", true)
+	v.SetDefault("relay.tls.profile", TLSProfileModern)
+	v.SetDefault("relay.tls.verify_cert", true)
+	v.SetDefault("relay.tls.cert_warn_before", "6h")
+	v.SetDefault("auth.type", "jwt")
+	v.SetDefault("auth.keycloak.enabled", false)
+	v.SetDefault("auth.validation.leeway", "30s")
+	v.SetDefault("auth.mtls.subject_source", "cn")
+	v.SetDefault("auth.mtls.tenant_source", "ou")
+	v.SetDefault("auth.credential_store.enabled", false)
+	v.SetDefault("auth.credential_store.identity", "default")
+	v.SetDefault("rate_limiting.enabled", true)
+	v.SetDefault("rate_limiting.max_retries", 3)
+	v.SetDefault("rate_limiting.backoff_multiplier", 2.0)
+	v.SetDefault("rate_limiting.max_backoff", "30s")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", "json")
+	v.SetDefault("logging.output", "stdout")
+	v.SetDefault("connections.max_connections", 0)
+	v.SetDefault("connections.on_limit", types.OnLimitReject)
+	v.SetDefault("connections.queue_timeout", "10s")
+	v.SetDefault("connections.max_queue", types.DefaultMaxQueue)
 }
 
 // CreateTLSConfig creates a TLS configuration from the config
 func CreateTLSConfig(c *types.Config) (*tls.Config, error) {
+	return CreateTLSConfigWithReloader(c, nil)
+}
+
+// CreateTLSConfigWithReloader creates a TLS configuration that presents the client certificate
+// of reloader when it is set. The certificate files are then not read again, so a pair caught
+// mid-rotation on disk does not fail the connection while the reloader holds a good one.
+func CreateTLSConfigWithReloader(c *types.Config, reloader *CertificateReloader) (*tls.Config, error) {
 	if !c.Relay.TLS.Enabled {
 		return nil, nil
 	}
 
+	profile, err := resolveTLSProfile(c.Relay.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS policy: %w", err)
+	}
+	if profile.weak() {
+		log.Printf("Warning: TLS profile %q allows TLS versions below 1.3", profile.name)
+	}
+
 	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS13,
-		CipherSuites: []uint16{
-			tls.TLS_AES_256_GCM_SHA384,
-			tls.TLS_CHACHA20_POLY1305_SHA256,
-			tls.TLS_AES_128_GCM_SHA256,
-		},
+		MinVersion:         profile.minVersion,
+		CipherSuites:       profile.cipherSuites,
+		CurvePreferences:   profile.curves,
+		NextProtos:         c.Relay.TLS.ALPN,
 		InsecureSkipVerify: !c.Relay.TLS.VerifyCert,
 	}
 
@@ -163,8 +96,18 @@ func CreateTLSConfig(c *types.Config) (*tls.Config, error) {
 		tlsConfig.RootCAs = caCertPool
 	}
 
+	// Pin the relay public key if configured, backup pins are accepted as well
+	if len(c.Relay.TLS.Pins) > 0 {
+		if len(c.Relay.TLS.BackupPins) == 0 {
+			log.Printf("Warning: relay TLS pinning is enabled without backup pins")
+		}
+		tlsConfig.VerifyConnection = pinVerifier(append(append([]string{}, c.Relay.TLS.Pins...), c.Relay.TLS.BackupPins...))
+	}
+
 	// Load client certificate if provided
-	if c.Relay.TLS.ClientCert != "" && c.Relay.TLS.ClientKey != "" {
+	if reloader != nil {
+		reloader.Apply(tlsConfig)
+	} else if c.Relay.TLS.ClientCert != "" && c.Relay.TLS.ClientKey != "" {
 		cert, certErr := tls.LoadX509KeyPair(c.Relay.TLS.ClientCert, c.Relay.TLS.ClientKey)
 		if certErr != nil {
 			return nil, fmt.Errorf("failed to load client certificate: %w", certErr)
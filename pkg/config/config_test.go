@@ -0,0 +1,128 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+func TestValidateTunnels(t *testing.T) {
+	disabled := false
+	valid := []types.TunnelConfig{
+		{ID: "rdp", LocalPort: 3389, RemoteHost: "10.0.0.10", RemotePort: 3389},
+		{ID: "db", Bind: "127.0.0.1", LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432, Protocol: "tcp"},
+		// Disabled tunnels may reuse a local address
+		{ID: "rdp-old", LocalPort: 3389, RemoteHost: "10.0.0.11", RemotePort: 3389, Enabled: &disabled},
+	}
+	var found problems
+	validateTunnels(&found, "tunnels", valid)
+	if len(found) > 0 {
+		t.Fatalf("expected tunnels to be valid, got %v", found)
+	}
+	validateTunnels(&found, "tunnels", []types.TunnelConfig{
+		{ID: "v6", Bind: "[::1]", LocalPort: 3389, RemoteHost: "h", RemotePort: 1},
+		{ID: "sock", Bind: "unix:///run/app.sock", Socket: types.SocketConfig{Mode: "0660", Group: "app"}, RemoteHost: "h", RemotePort: 1},
+	})
+	if len(found) > 0 {
+		t.Fatalf("expected bind addresses to be valid, got %v", found)
+	}
+
+	tests := []struct {
+		name    string
+		tunnels []types.TunnelConfig
+		want    string
+	}{
+		{"missing id", []types.TunnelConfig{{LocalPort: 1, RemoteHost: "h", RemotePort: 1}}, "tunnels[0].id: id is required"},
+		{"duplicate id", append(valid, types.TunnelConfig{ID: "db", LocalPort: 2, RemoteHost: "h", RemotePort: 1}), "tunnels[3].id: duplicate id"},
+		{"duplicate address", append(valid, types.TunnelConfig{ID: "rdp-2", LocalPort: 3389, RemoteHost: "h", RemotePort: 1}), "already used"},
+		{"bad protocol", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h", RemotePort: 1, Protocol: "sctp"}}, "tunnels[0].protocol"},
+		{"bad remote port", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h"}}, "invalid remote port"},
+		{"bad on_limit", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h", RemotePort: 1,
+			Limits: types.TunnelLimitsConfig{OnLimit: "drop"}}}, "tunnels[0].limits.on_limit"},
+		{"bad access entry", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h", RemotePort: 1,
+			Access: types.AccessConfig{Allow: []string{"10.0.0.0/8", "10.0.0.300"}}}}, "tunnels[0].access.allow[1]: invalid CIDR"},
+		{"negative idle timeout", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h", RemotePort: 1,
+			IdleTimeout: -time.Second}}, "tunnels[0].idle_timeout"},
+		{"bad bind", []types.TunnelConfig{{ID: "x", Bind: "eth0", LocalPort: 1, RemoteHost: "h", RemotePort: 1}}, "tunnels[0].bind"},
+		{"relative socket", []types.TunnelConfig{{ID: "x", Bind: "unix://app.sock", RemoteHost: "h", RemotePort: 1}}, "must be absolute"},
+		{"bad socket mode", []types.TunnelConfig{{ID: "x", Bind: "unix:///run/app.sock", RemoteHost: "h", RemotePort: 1,
+			Socket: types.SocketConfig{Mode: "0999"}}}, "tunnels[0].socket.mode"},
+		{"socket without unix bind", []types.TunnelConfig{{ID: "x", LocalPort: 1, RemoteHost: "h", RemotePort: 1,
+			Socket: types.SocketConfig{Mode: "0660"}}}, "tunnels[0].socket"},
+		{"duplicate socket", []types.TunnelConfig{
+			{ID: "a", Bind: "unix:///run/app.sock", RemoteHost: "h", RemotePort: 1},
+			{ID: "b", Bind: "unix:///run/app.sock", RemoteHost: "h", RemotePort: 2},
+		}, "tunnels[1].bind: local address unix:///run/app.sock is already used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found problems
+			validateTunnels(&found, "tunnels", tt.tunnels)
+			err := (&ValidationError{Problems: found}).Error()
+			if len(found) == 0 || !strings.Contains(err, tt.want) {
+				t.Errorf("expected error containing %q, got %q", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := &types.Config{
+		Relay: types.RelayConfig{Port: 70000},
+		Auth:  types.AuthConfig{Type: "jwt"},
+		RateLimiting: types.RateLimitingConfig{
+			BackoffMultiplier: 2,
+		},
+	}
+
+	found := Validate(cfg)
+	paths := make(map[string]bool)
+	for _, problem := range found {
+		paths[problem.Path] = true
+	}
+	for _, path := range []string{"relay.host", "relay.port", "auth.secret"} {
+		if !paths[path] {
+			t.Errorf("expected a problem at %s, got %v", path, found)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	settings := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"type":   "jwt",
+			"secret": "s3cret",
+			"keycloak": map[string]interface{}{
+				"client_secret": "kc",
+			},
+		},
+		"identities": []interface{}{
+			map[string]interface{}{"name": "a", "access_token": "t"},
+		},
+		"relay": map[string]interface{}{
+			"tls": map[string]interface{}{"client_key": "/etc/key.pem"},
+		},
+	}
+
+	redacted := Redact(settings)
+	auth := redacted["auth"].(map[string]interface{})
+	if auth["secret"] != Redacted || auth["type"] != "jwt" {
+		t.Errorf("unexpected auth settings: %v", auth)
+	}
+	if auth["keycloak"].(map[string]interface{})["client_secret"] != Redacted {
+		t.Errorf("nested secret not redacted: %v", auth["keycloak"])
+	}
+	identity := redacted["identities"].([]interface{})[0].(map[string]interface{})
+	if identity["access_token"] != Redacted || identity["name"] != "a" {
+		t.Errorf("secret in list not redacted: %v", identity)
+	}
+	// File paths are not secrets
+	if redacted["relay"].(map[string]interface{})["tls"].(map[string]interface{})["client_key"] != "/etc/key.pem" {
+		t.Errorf("client_key path should be kept")
+	}
+	if settings["auth"].(map[string]interface{})["secret"] != "s3cret" {
+		t.Errorf("input settings were modified")
+	}
+}
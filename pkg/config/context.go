@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownContext is returned when the selected context is not defined in contexts
+var ErrUnknownContext = errors.New("unknown context")
+
+// contextKeys are the top-level settings a context overrides
+var contextKeys = []string{"relay", "auth", "tunnels"}
+
+// SetContext selects the context applied by Read, overriding current_context.
+// An empty name uses current_context from the configuration.
+func (l *Loader) SetContext(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.context = name
+}
+
+// applyContext merges the selected context over the file settings and returns its name.
+// Must be called with l.mu held after the configuration file was read.
+func (l *Loader) applyContext() (string, error) {
+	name := l.context
+	if name == "" {
+		name = l.v.GetString("current_context")
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	settings, err := findContext(l.v.Get("contexts"), name)
+	if err != nil {
+		return "", err
+	}
+
+	overrides := make(map[string]interface{})
+	for _, key := range contextKeys {
+		if value, ok := settings[key]; ok && value != nil {
+			overrides[key] = value
+		}
+	}
+	if err := l.v.MergeConfigMap(overrides); err != nil {
+		return "", fmt.Errorf("failed to apply context %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// findContext returns the raw settings of the named context
+func findContext(raw interface{}, name string) (map[string]interface{}, error) {
+	items, _ := raw.([]interface{})
+	for _, item := range items {
+		settings, ok := item.(map[string]interface{})
+		if ok && fmt.Sprint(settings["name"]) == name {
+			return settings, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownContext, name)
+}
+
+// SetCurrentContext stores name as current_context in the configuration file at path.
+// The context must be defined in the file; comments and the order of the other settings are kept.
+func SetCurrentContext(path, name string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var file struct {
+		Contexts []struct {
+			Name string `yaml:"name"`
+		} `yaml:"contexts"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	found := false
+	for _, ctx := range file.Contexts {
+		found = found || ctx.Name == name
+	}
+	if !found {
+		return fmt.Errorf("%w %q", ErrUnknownContext, name)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("failed to parse config: top level is not a mapping")
+	}
+	setMappingValue(doc.Content[0], "current_context", name)
+
+	out, err := encodeYAML(&doc)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, out, info.Mode().Perm())
+}
+
+// setMappingValue sets key to a string value in a YAML mapping node, appending it when missing
+func setMappingValue(mapping *yaml.Node, key, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1].Kind = yaml.ScalarNode
+			mapping.Content[i+1].Tag = "!!str"
+			mapping.Content[i+1].Value = value
+			mapping.Content[i+1].Content = nil
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle},
+	)
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const contextsConfig = `# relays
+relay:
+  host: edge.example.com
+  tls:
+    server_name: edge.example.com
+auth:
+  secret: base-secret
+tunnels:
+  - id: base
+    local_port: 10001
+    remote_host: h
+    remote_port: 1
+current_context: staging
+contexts:
+  - name: staging
+    relay:
+      host: staging.example.com
+  - name: production
+    relay:
+      host: prod.example.com
+      port: 9443
+    auth:
+      secret: prod-secret
+    tunnels:
+      - id: prod
+        local_port: 10002
+        remote_host: h
+        remote_port: 1
+`
+
+func TestLoaderAppliesCurrentContext(t *testing.T) {
+	cfg, err := NewLoader(writeConfig(t, contextsConfig)).Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.CurrentContext != "staging" || cfg.Relay.Host != "staging.example.com" {
+		t.Errorf("expected staging relay, got %s in context %q", cfg.Relay.Host, cfg.CurrentContext)
+	}
+	// Settings missing from the context are inherited
+	if cfg.Relay.TLS.ServerName != "edge.example.com" || cfg.Auth.Secret != "base-secret" || len(cfg.Tunnels) != 1 {
+		t.Errorf("expected inherited settings, got %+v", cfg)
+	}
+}
+
+func TestLoaderSetContext(t *testing.T) {
+	loader := NewLoader(writeConfig(t, contextsConfig))
+	loader.SetContext("production")
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.CurrentContext != "production" || cfg.Relay.Host != "prod.example.com" || cfg.Relay.Port != 9443 {
+		t.Errorf("expected production relay, got %s:%d", cfg.Relay.Host, cfg.Relay.Port)
+	}
+	if cfg.Auth.Secret != "prod-secret" {
+		t.Errorf("expected production secret, got %s", cfg.Auth.Secret)
+	}
+	if len(cfg.Tunnels) != 1 || cfg.Tunnels[0].ID != "prod" {
+		t.Errorf("expected context tunnels to replace the top-level ones, got %+v", cfg.Tunnels)
+	}
+
+	loader.SetContext("missing")
+	if _, err := loader.Load(); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("expected ErrUnknownContext, got %v", err)
+	}
+}
+
+func TestSetCurrentContext(t *testing.T) {
+	path := writeConfig(t, contextsConfig)
+
+	if err := SetCurrentContext(path, "production"); err != nil {
+		t.Fatalf("failed to set context: %v", err)
+	}
+	cfg, err := NewLoader(path).Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.CurrentContext != "production" {
+		t.Errorf("expected production, got %q", cfg.CurrentContext)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(data), "# relays") {
+		t.Error("comments were not kept")
+	}
+
+	if err := SetCurrentContext(path, "missing"); !errors.Is(err, ErrUnknownContext) {
+		t.Errorf("expected ErrUnknownContext, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+const (
+	// EncryptedPrefix marks encrypted configuration values: enc:v1:<key id>:<base64 nonce and ciphertext>
+	EncryptedPrefix = "enc:v1:"
+	// DefaultKeyFileName is the key file looked up next to the configuration file
+	DefaultKeyFileName = "config.key"
+
+	// configKeySize is the size of the AES-256 key
+	configKeySize = 32
+)
+
+// ErrKeyMismatch is returned when a value was encrypted with a different key
+var ErrKeyMismatch = errors.New("value was encrypted with a different key")
+
+// encryptedValuePattern matches encrypted values inside a configuration file
+var encryptedValuePattern = regexp.MustCompile(`enc:v1:[0-9a-f]+:[A-Za-z0-9_-]+`)
+
+// ConfigKey encrypts and decrypts configuration values with AES-256-GCM
+type ConfigKey struct {
+	key []byte
+	id  string
+}
+
+// newConfigKey wraps raw key material
+func newConfigKey(key []byte) (*ConfigKey, error) {
+	if len(key) != configKeySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d bytes", len(key), configKeySize)
+	}
+	sum := sha256.Sum256(key)
+	return &ConfigKey{key: key, id: hex.EncodeToString(sum[:4])}, nil
+}
+
+// GenerateConfigKey creates a random key
+func GenerateConfigKey() (*ConfigKey, error) {
+	key := make([]byte, configKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return newConfigKey(key)
+}
+
+// LoadConfigKey reads a base64 key from path, rejecting files readable by other users
+func LoadConfigKey(path string) (*ConfigKey, error) {
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			return nil, fmt.Errorf("insecure permissions %#o on %s, expected 0600", perm, path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return newConfigKey(key)
+}
+
+// Save writes the key to path with 0600 permissions
+func (k *ConfigKey) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	return writeFileAtomic(path, []byte(base64.StdEncoding.EncodeToString(k.key)+"\n"), 0600)
+}
+
+// ID identifies the key in encrypted values
+func (k *ConfigKey) ID() string {
+	return k.id
+}
+
+// Encrypt returns the enc:v1 form of plaintext
+func (k *ConfigKey) Encrypt(plaintext string) (string, error) {
+	gcm, err := k.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(EncryptedPrefix+k.id))
+	return EncryptedPrefix + k.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of an enc:v1 value
+func (k *ConfigKey) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, EncryptedPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	if id != k.id {
+		return "", fmt.Errorf("%w %s, the key file has id %s", ErrKeyMismatch, id, k.id)
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	gcm, err := k.aead()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(EncryptedPrefix+id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// aead returns the AES-GCM cipher of the key
+func (k *ConfigKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether value is an encrypted configuration value
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// hasEncryptedValues reports whether any string setting of c is encrypted
+func hasEncryptedValues(c *types.Config) bool {
+	found := false
+	_ = transformStrings(reflect.ValueOf(c).Elem(), "", func(value string) (string, error) {
+		found = found || IsEncrypted(value)
+		return value, nil
+	})
+	return found
+}
+
+// RotateKeyResult describes a key rotation
+type RotateKeyResult struct {
+	Values    int
+	KeyBackup string
+}
+
+// RotateKey re-encrypts every encrypted value of the configuration file at configPath with
+// a new key and replaces the key file. The old key is kept next to it with a .bak suffix
+// and restored if the configuration cannot be written.
+func RotateKey(configPath, keyFile string) (*RotateKeyResult, error) {
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	oldKey, err := LoadConfigKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := GenerateConfigKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Values are replaced in the text so comments and layout are kept
+	result := &RotateKeyResult{}
+	var rotateErr error
+	rotated := encryptedValuePattern.ReplaceAllStringFunc(string(data), func(value string) string {
+		if rotateErr != nil {
+			return value
+		}
+		plaintext, err := oldKey.Decrypt(value)
+		if err != nil {
+			rotateErr = err
+			return value
+		}
+		encrypted, err := newKey.Encrypt(plaintext)
+		if err != nil {
+			rotateErr = err
+			return value
+		}
+		result.Values++
+		return encrypted
+	})
+	if rotateErr != nil {
+		return nil, fmt.Errorf("failed to re-encrypt config: %w", rotateErr)
+	}
+
+	result.KeyBackup = fmt.Sprintf("%s.%s.bak", keyFile, time.Now().Format("20060102-150405"))
+	if err := oldKey.Save(result.KeyBackup); err != nil {
+		return nil, fmt.Errorf("failed to back up key: %w", err)
+	}
+	if err := newKey.Save(keyFile); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := writeFileAtomic(configPath, []byte(rotated), info.Mode().Perm()); err != nil {
+		if restoreErr := oldKey.Save(keyFile); restoreErr != nil {
+			return nil, fmt.Errorf("%w, and restoring the old key failed: %v", err, restoreErr)
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T, path string) *ConfigKey {
+	t.Helper()
+	key, err := GenerateConfigKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if err := key.Save(path); err != nil {
+		t.Fatalf("failed to save key: %v", err)
+	}
+	return key
+}
+
+func TestConfigKeyRoundTrip(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "config.key")
+	key := newTestKey(t, keyFile)
+
+	encrypted, err := key.Encrypt("s3cret")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "s3cret") {
+		t.Fatalf("unexpected encrypted value %q", encrypted)
+	}
+
+	loaded, err := LoadConfigKey(keyFile)
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	if plaintext, err := loaded.Decrypt(encrypted); err != nil || plaintext != "s3cret" {
+		t.Errorf("expected s3cret, got %q, %v", plaintext, err)
+	}
+
+	other, err := GenerateConfigKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if _, err := key.Decrypt(tampered); err == nil {
+		t.Error("expected tampered value to fail")
+	}
+}
+
+func TestLoaderDecryptsValues(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t, filepath.Join(dir, DefaultKeyFileName))
+	encrypted, err := key.Encrypt("from-key")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("auth:\n  secret: "+encrypted+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	loader := NewLoader(path)
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Auth.Secret != "from-key" {
+		t.Errorf("expected decrypted secret, got %q", cfg.Auth.Secret)
+	}
+	if secret := loader.Settings()["auth"].(map[string]interface{})["secret"]; secret != encrypted {
+		t.Errorf("plaintext leaked into settings: %v", secret)
+	}
+}
+
+func TestLoaderKeepsDecryptedRefsLiteral(t *testing.T) {
+	t.Setenv("TEST_DECRYPTED_REF", "expanded")
+	dir := t.TempDir()
+	key := newTestKey(t, filepath.Join(dir, DefaultKeyFileName))
+	encrypted, err := key.Encrypt("${env:TEST_DECRYPTED_REF}")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("auth:\n  secret: "+encrypted+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := NewLoader(path).Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Auth.Secret != "${env:TEST_DECRYPTED_REF}" {
+		t.Errorf("expected decrypted value to stay unexpanded, got %q", cfg.Auth.Secret)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, DefaultKeyFileName)
+	key := newTestKey(t, keyFile)
+	encrypted, err := key.Encrypt("rotate-me")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	content := "# keep\nauth:\n  secret: " + encrypted + " # inline\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	result, err := RotateKey(path, keyFile)
+	if err != nil {
+		t.Fatalf("failed to rotate key: %v", err)
+	}
+	if result.Values != 1 {
+		t.Errorf("expected 1 value, got %d", result.Values)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if strings.Contains(string(data), encrypted) || !strings.Contains(string(data), "# inline") {
+		t.Errorf("unexpected rotated config:\n%s", data)
+	}
+
+	cfg, err := NewLoader(path).Load()
+	if err != nil || cfg.Auth.Secret != "rotate-me" {
+		t.Fatalf("expected rotated secret to load, got %v", err)
+	}
+	if old, err := LoadConfigKey(result.KeyBackup); err != nil || old.ID() != key.ID() {
+		t.Errorf("old key was not backed up: %v", err)
+	}
+}
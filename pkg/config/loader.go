@@ -0,0 +1,448 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of environment variables overriding configuration keys.
+// Nested keys map to upper case names with dots replaced by underscores,
+// e.g. relay.tls.ca_cert is read from CLOUDBRIDGE_RELAY_TLS_CA_CERT.
+const EnvPrefix = "CLOUDBRIDGE"
+
+// Sources of configuration values, in increasing priority
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceUnset   = "unset"
+)
+
+// Loader loads the configuration from layered sources into its own viper instance,
+// so several configurations can be loaded in one process
+type Loader struct {
+	v          *viper.Viper
+	configPath string
+	flags      map[string]*pflag.Flag
+	context    string
+	// migrationLogged suppresses repeated migration warnings on reload
+	migrationLogged bool
+	mu              sync.Mutex
+}
+
+// NewLoader creates a loader reading configPath, or searching the default
+// locations for config.yaml when configPath is empty
+func NewLoader(configPath string) *Loader {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
+	v.AddConfigPath("/etc/cloudbridge-client")
+	v.AddConfigPath("$HOME/.cloudbridge-client")
+	if configPath != "" {
+		v.SetConfigFile(configPath)
+	}
+
+	// Read environment variables for every known key, including nested ones.
+	// A variable set to an empty string overrides the key with an empty value.
+	v.SetEnvPrefix(EnvPrefix)
+	v.AllowEmptyEnv(true)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	for _, key := range ConfigKeys() {
+		if err := v.BindEnv(key); err != nil {
+			_ = err // Ключ всегда непустой
+		}
+	}
+
+	setDefaults(v)
+
+	return &Loader{
+		v:          v,
+		configPath: configPath,
+		flags:      make(map[string]*pflag.Flag),
+	}
+}
+
+// BindFlag makes flag override key when it is set on the command line
+func (l *Loader) BindFlag(key string, flag *pflag.Flag) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.v.BindPFlag(key, flag); err != nil {
+		return fmt.Errorf("failed to bind flag %s: %w", flag.Name, err)
+	}
+	l.flags[key] = flag
+	return nil
+}
+
+// Load reads every source and returns the validated configuration
+func (l *Loader) Load() (*types.Config, error) {
+	config, found, err := l.read(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if found = mergeProblems(found, Validate(config)); len(found) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", &ValidationError{Problems: found})
+	}
+
+	return config, nil
+}
+
+// Check reads every source and returns the configuration with every problem found
+// by the schema, in secret references and encrypted values, and by Check.
+// Secrets that cannot be resolved are reported and left as written, so the
+// remaining settings are still checked.
+func (l *Loader) Check() (*types.Config, []Problem, error) {
+	config, found, err := l.read(false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l.mu.Lock()
+	secretProblems := l.resolveSecrets(config)
+	l.mu.Unlock()
+
+	return config, mergeProblems(mergeProblems(found, secretProblems), Check(config)), nil
+}
+
+// Read reads every source and returns the merged configuration without validating it.
+// Secret references such as ${file:/run/secrets/jwt} are resolved on every call.
+func (l *Loader) Read() (*types.Config, error) {
+	config, _, err := l.read(true)
+	return config, err
+}
+
+// ReadUnresolved reads every source like Read but leaves secret references and enc:v1
+// values as written, so commands that only display the configuration never run
+// ${exec:...} commands, read secret files or need the config key
+func (l *Loader) ReadUnresolved() (*types.Config, error) {
+	config, _, err := l.read(false)
+	return config, err
+}
+
+// read reads every source and returns the configuration with the problems found by the schema,
+// resolving secrets when resolve is set.
+// Schema problems are only an error when the settings cannot be decoded at all.
+func (l *Loader) read(resolve bool) (*types.Config, []Problem, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, nil, fmt.Errorf("failed to read config: %w", err)
+		}
+	}
+
+	if err := l.migrate(); err != nil {
+		return nil, nil, err
+	}
+
+	contextName, err := l.applyContext()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := ValidateSettings(configSchema(), l.v.AllSettings())
+
+	var config types.Config
+	if err := l.v.Unmarshal(&config); err != nil {
+		if len(found) > 0 {
+			return nil, nil, fmt.Errorf("invalid configuration: %w", &ValidationError{Problems: found})
+		}
+		return nil, nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	config.CurrentContext = contextName
+	if !resolve {
+		return &config, found, nil
+	}
+
+	if secretProblems := l.resolveSecrets(&config); len(secretProblems) > 0 {
+		return nil, nil, fmt.Errorf("failed to resolve secrets: %w", &ValidationError{Problems: secretProblems})
+	}
+
+	return &config, found, nil
+}
+
+// resolveSecrets resolves secret references and then decrypts enc:v1 values in config,
+// in that order so decrypted plaintext is used as written and never expanded.
+// Settings that fail are left as written and reported at their key, so every failure
+// is found in one pass. Resolved secrets only live in config, never in the settings.
+// Must be called with l.mu held.
+func (l *Loader) resolveSecrets(config *types.Config) []Problem {
+	var p problems
+	fields := reflect.ValueOf(config).Elem()
+
+	_ = walkStrings(fields, "", func(path, value string) (string, error) {
+		resolved, err := resolveString(value)
+		if err != nil {
+			p.add(path, "%v", err)
+			return value, nil
+		}
+		return resolved, nil
+	})
+
+	if hasEncryptedValues(config) {
+		key, err := LoadConfigKey(l.keyFile(config.Encryption.KeyFile))
+		if err != nil {
+			p.add("encryption.key_file", "failed to load config key: %v", err)
+			return p
+		}
+		_ = walkStrings(fields, "", func(path, value string) (string, error) {
+			if !IsEncrypted(value) {
+				return value, nil
+			}
+			plaintext, err := key.Decrypt(value)
+			if err != nil {
+				p.add(path, "%v", err)
+				return value, nil
+			}
+			return plaintext, nil
+		})
+	}
+
+	return p
+}
+
+// Settings returns the merged settings of the last Read as nested maps keyed like the file
+func (l *Loader) Settings() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.v.AllSettings()
+}
+
+// FileSettings returns only the settings present in the configuration file
+func (l *Loader) FileSettings() (map[string]interface{}, error) {
+	file := l.ConfigFileUsed()
+	if file == "" {
+		return map[string]interface{}{}, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return v.AllSettings(), nil
+}
+
+// migrate upgrades an older configuration file layout in memory.
+// Must be called with l.mu held after the configuration file was read.
+func (l *Loader) migrate() error {
+	file := l.v.ConfigFileUsed()
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	result, migrated, err := MigrateData(file, data)
+	if err != nil {
+		return fmt.Errorf("failed to migrate config: %w", err)
+	}
+	if !result.Changed() {
+		return nil
+	}
+
+	if !l.migrationLogged {
+		log.Printf("Warning: configuration %s uses format version %d, run `cloudbridge-client config migrate` to upgrade it", file, result.From)
+		for _, note := range result.Notes {
+			log.Printf("Warning: config migration: %s", note)
+		}
+		l.migrationLogged = true
+	}
+	if err := l.v.ReadConfig(bytes.NewReader(migrated)); err != nil {
+		return fmt.Errorf("failed to read migrated config: %w", err)
+	}
+	return nil
+}
+
+// FindConfigFile reads the configuration file without applying contexts and returns its path
+func (l *Loader) FindConfigFile() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			return "", fmt.Errorf("no configuration file found")
+		}
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+	return l.v.ConfigFileUsed(), nil
+}
+
+// KeyFile returns the key file for encrypted values of the last read configuration
+func (l *Loader) KeyFile() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.keyFile(l.v.GetString("encryption.key_file"))
+}
+
+// keyFile returns configured, or config.key next to the configuration file when empty.
+// Must be called with l.mu held.
+func (l *Loader) keyFile(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	dir := "."
+	if used := l.v.ConfigFileUsed(); used != "" {
+		dir = filepath.Dir(used)
+	}
+	return filepath.Join(dir, DefaultKeyFileName)
+}
+
+// ConfigFileUsed returns the configuration file read by the last Load
+func (l *Loader) ConfigFileUsed() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.v.ConfigFileUsed()
+}
+
+// ConfigDir returns the directory of the configuration file, or "." when none was read
+func (l *Loader) ConfigDir() string {
+	if used := l.ConfigFileUsed(); used != "" {
+		return filepath.Dir(used)
+	}
+	return "."
+}
+
+// Source returns where the effective value of key came from
+func (l *Loader) Source(key string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key = strings.ToLower(key)
+	if flag, ok := l.flags[key]; ok && flag.Changed {
+		return SourceFlag
+	}
+	if _, ok := os.LookupEnv(EnvVar(key)); ok {
+		return SourceEnv
+	}
+	if l.v.InConfig(key) {
+		return SourceFile
+	}
+	if l.v.IsSet(key) {
+		return SourceDefault
+	}
+	return SourceUnset
+}
+
+// Sources returns the source of every known key
+func (l *Loader) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, key := range ConfigKeys() {
+		sources[key] = l.Source(key)
+	}
+	return sources
+}
+
+// Watch calls onChange whenever the configuration file changes on disk, until stop is closed.
+// Nothing is watched when no configuration file was read.
+func (l *Loader) Watch(onChange func(), stop <-chan struct{}) error {
+	file := l.ConfigFileUsed()
+	if file == "" {
+		return nil
+	}
+	file = filepath.Clean(file)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	// Watch the directory so files replaced by rename are picked up as well
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		if cerr := watcher.Close(); cerr != nil {
+			_ = cerr // Игнорируем ошибку закрытия наблюдателя при ошибке запуска
+		}
+		return fmt.Errorf("failed to watch %s: %w", file, err)
+	}
+
+	go func() {
+		defer func() {
+			if err := watcher.Close(); err != nil {
+				_ = err // Игнорируем ошибку закрытия наблюдателя
+			}
+		}()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher error: %v", err)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// EnvVar returns the environment variable overriding key
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ConfigKeys returns the dotted keys of every scalar setting in types.Config.
+// Lists of structures such as tunnels and identities are only read from files.
+func ConfigKeys() []string {
+	var keys []string
+	collectKeys(reflect.TypeOf(types.Config{}), "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+// collectKeys appends the mapstructure keys of t's fields under prefix
+func collectKeys(t reflect.Type, prefix string, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			collectKeys(field.Type, key, keys)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.Struct {
+				continue
+			}
+			*keys = append(*keys, key)
+		default:
+			*keys = append(*keys, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoaderIsolation(t *testing.T) {
+	a := writeConfig(t, "relay:\n  host: a.example.com\nauth:\n  secret: secret-a\n")
+	b := writeConfig(t, "relay:\n  port: 9443\nauth:\n  secret: secret-b\n")
+
+	cfgA, err := NewLoader(a).Load()
+	if err != nil {
+		t.Fatalf("failed to load config a: %v", err)
+	}
+	cfgB, err := NewLoader(b).Load()
+	if err != nil {
+		t.Fatalf("failed to load config b: %v", err)
+	}
+
+	if cfgA.Relay.Host != "a.example.com" || cfgA.Relay.Port != 8080 {
+		t.Errorf("unexpected relay for config a: %s:%d", cfgA.Relay.Host, cfgA.Relay.Port)
+	}
+	if cfgB.Relay.Host != "edge.2gc.ru" || cfgB.Relay.Port != 9443 {
+		t.Errorf("config a leaked into config b: %s:%d", cfgB.Relay.Host, cfgB.Relay.Port)
+	}
+}
+
+func TestLoaderSources(t *testing.T) {
+	path := writeConfig(t, "relay:\n  host: file.example.com\n  tls:\n    server_name: file.example.com\nauth:\n  secret: from-file\n  keycloak:\n    realm: from-file\n")
+	t.Setenv("CLOUDBRIDGE_RELAY_TLS_SERVER_NAME", "env.example.com")
+	t.Setenv("CLOUDBRIDGE_AUTH_OIDC_ISSUER_URL", "https://issuer.example.com")
+	t.Setenv("CLOUDBRIDGE_AUTH_KEYCLOAK_REALM", "")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("relay-host", "", "")
+	if err := flags.Parse([]string{"--relay-host", "flag.example.com"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	loader := NewLoader(path)
+	if err := loader.BindFlag("relay.host", flags.Lookup("relay-host")); err != nil {
+		t.Fatalf("failed to bind flag: %v", err)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Relay.Host != "flag.example.com" {
+		t.Errorf("expected flag to override relay host, got %s", cfg.Relay.Host)
+	}
+	if cfg.Relay.TLS.ServerName != "env.example.com" {
+		t.Errorf("expected nested env override of server name, got %s", cfg.Relay.TLS.ServerName)
+	}
+	if cfg.Auth.Keycloak.Realm != "" {
+		t.Errorf("expected empty env to override the file, got %q", cfg.Auth.Keycloak.Realm)
+	}
+	if cfg.Auth.OIDC.IssuerURL != "https://issuer.example.com" {
+		t.Errorf("expected env to set a key absent from the file, got %q", cfg.Auth.OIDC.IssuerURL)
+	}
+
+	expected := map[string]string{
+		"relay.host":            SourceFlag,
+		"relay.tls.server_name": SourceEnv,
+		"auth.secret":           SourceFile,
+		"relay.port":            SourceDefault,
+		"relay.tls.ca_cert":     SourceUnset,
+		"auth.keycloak.realm":   SourceEnv,
+	}
+	for key, source := range expected {
+		if got := loader.Source(key); got != source {
+			t.Errorf("expected source of %s to be %s, got %s", key, source, got)
+		}
+	}
+}
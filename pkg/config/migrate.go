@@ -0,0 +1,351 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the configuration format version written by this release.
+// Files without a version field use the current layout unless legacy keys are found.
+const CurrentVersion = 1
+
+// Migration upgrades raw settings from one format version to the next
+type Migration struct {
+	From        int
+	Description string
+	// Apply rewrites settings in place and returns notes about settings it could not carry over
+	Apply func(settings map[string]interface{}) []string
+}
+
+// migrations are applied in order, each from its From version to the next one
+var migrations = []Migration{
+	{From: 0, Description: "move legacy flat and server/tunnel settings into relay, auth and rate_limiting", Apply: migrateLegacyLayout},
+}
+
+// MigrationResult describes an upgrade of a configuration
+type MigrationResult struct {
+	From  int
+	To    int
+	Steps []string
+	Notes []string
+}
+
+// Changed reports whether the configuration had to be upgraded
+func (r *MigrationResult) Changed() bool {
+	return r.From != r.To
+}
+
+// legacyFlatKeys are the keys of the flat relay.Config layout, in snake case and as
+// matched by field name, with their place in the current layout
+var legacyFlatKeys = map[string]string{
+	"use_tls":         "relay.tls.enabled",
+	"usetls":          "relay.tls.enabled",
+	"tls_cert_file":   "relay.tls.client_cert",
+	"tlscertfile":     "relay.tls.client_cert",
+	"tls_key_file":    "relay.tls.client_key",
+	"tlskeyfile":      "relay.tls.client_key",
+	"tls_ca_file":     "relay.tls.ca_cert",
+	"tlscafile":       "relay.tls.ca_cert",
+	"server_host":     "relay.host",
+	"serverhost":      "relay.host",
+	"server_port":     "relay.port",
+	"serverport":      "relay.port",
+	"jwt_token":       "auth.secret",
+	"jwttoken":        "auth.secret",
+	"max_retries":     "rate_limiting.max_retries",
+	"maxretries":      "rate_limiting.max_retries",
+	"local_port":      "",
+	"localport":       "",
+	"reconnect_delay": "",
+	"reconnectdelay":  "",
+}
+
+// legacyNestedKeys are the settings of the server/tunnel layout with their place in the current layout
+var legacyNestedKeys = map[string]string{
+	"tls.enabled":            "relay.tls.enabled",
+	"tls.cert_file":          "relay.tls.client_cert",
+	"tls.key_file":           "relay.tls.client_key",
+	"tls.ca_file":            "relay.tls.ca_cert",
+	"server.host":            "relay.host",
+	"server.port":            "relay.port",
+	"server.jwt_token":       "auth.secret",
+	"tunnel.max_retries":     "rate_limiting.max_retries",
+	"tunnel.local_port":      "",
+	"tunnel.reconnect_delay": "",
+	"metrics.port":           "metrics.prometheus_port",
+	"metrics.path":           "",
+	"metrics.interval":       "",
+	"health":                 "",
+	"logging.file":           "logging.output",
+	"logging.max_size":       "",
+	"logging.max_backups":    "",
+	"logging.max_age":        "",
+	"logging.compress":       "",
+}
+
+// DetectVersion returns the format version of raw settings
+func DetectVersion(settings map[string]interface{}) (int, error) {
+	if raw, ok := settings["version"]; ok && raw != nil {
+		version, ok := toNumber(raw)
+		if !ok || version != float64(int(version)) || version < 0 {
+			return 0, fmt.Errorf("invalid config version %v", raw)
+		}
+		return int(version), nil
+	}
+
+	for key := range settings {
+		if _, ok := legacyFlatKeys[key]; ok {
+			return 0, nil
+		}
+	}
+	for _, key := range []string{"server", "tunnel", "tls", "health"} {
+		if _, ok := settings[key]; ok {
+			return 0, nil
+		}
+	}
+	return CurrentVersion, nil
+}
+
+// Migrate upgrades raw settings in place to CurrentVersion
+func Migrate(settings map[string]interface{}) (*MigrationResult, error) {
+	version, err := DetectVersion(settings)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than the supported version %d", version, CurrentVersion)
+	}
+
+	result := &MigrationResult{From: version, To: CurrentVersion}
+	for _, migration := range migrations {
+		if migration.From < version {
+			continue
+		}
+		result.Notes = append(result.Notes, migration.Apply(settings)...)
+		result.Steps = append(result.Steps, fmt.Sprintf("%d -> %d: %s", migration.From, migration.From+1, migration.Description))
+	}
+	settings["version"] = CurrentVersion
+
+	return result, nil
+}
+
+// migrateLegacyLayout moves settings of the flat relay.Config layout and of the
+// server/tunnel layout to their current keys
+func migrateLegacyLayout(settings map[string]interface{}) []string {
+	var notes []string
+
+	move := func(from, to string, value interface{}) {
+		if to == "" {
+			notes = append(notes, fmt.Sprintf("%s has no equivalent and was removed", from))
+			return
+		}
+		if existing := lookupSetting(settings, to); existing != nil {
+			notes = append(notes, fmt.Sprintf("%s was dropped, %s is already set", from, to))
+			return
+		}
+		setSetting(settings, to, value)
+	}
+
+	flat := make([]string, 0)
+	for key := range settings {
+		if _, ok := legacyFlatKeys[key]; ok {
+			flat = append(flat, key)
+		}
+	}
+	sort.Strings(flat)
+	for _, key := range flat {
+		value := settings[key]
+		delete(settings, key)
+		move(key, legacyFlatKeys[key], value)
+	}
+
+	nested := make([]string, 0, len(legacyNestedKeys))
+	for key := range legacyNestedKeys {
+		nested = append(nested, key)
+	}
+	sort.Strings(nested)
+	for _, key := range nested {
+		value, ok := removeSetting(settings, key)
+		if ok {
+			move(key, legacyNestedKeys[key], value)
+		}
+	}
+
+	// Sections emptied by the moves above
+	for _, key := range []string{"tls", "server", "tunnel"} {
+		if section, ok := settings[key].(map[string]interface{}); ok {
+			for name := range section {
+				notes = append(notes, fmt.Sprintf("%s.%s has no equivalent and was removed", key, name))
+			}
+			delete(settings, key)
+		}
+	}
+
+	if lookupSetting(settings, "auth.secret") != nil && lookupSetting(settings, "auth.type") == nil {
+		setSetting(settings, "auth.type", "jwt")
+	}
+	return notes
+}
+
+// lookupSetting returns the value at a dotted key path, or nil
+func lookupSetting(settings map[string]interface{}, key string) interface{} {
+	parts := strings.Split(key, ".")
+	current := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return current[parts[len(parts)-1]]
+}
+
+// setSetting stores value at a dotted key path, creating intermediate sections
+func setSetting(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	current := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// removeSetting deletes the value at a dotted key path and returns it
+func removeSetting(settings map[string]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	current := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	value, ok := current[parts[len(parts)-1]]
+	delete(current, parts[len(parts)-1])
+	return value, ok
+}
+
+// readSettings parses a configuration file into settings with lower case keys, as viper does
+func readSettings(path string, data []byte) (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return v.AllSettings(), nil
+}
+
+// MigrateData upgrades the YAML configuration in data and returns the new content.
+// Files that only lack the version field keep their comments and layout.
+func MigrateData(path string, data []byte) (*MigrationResult, []byte, error) {
+	settings, err := readSettings(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, versioned := settings["version"]
+
+	result, err := Migrate(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !result.Changed() {
+		if versioned {
+			return result, data, nil
+		}
+		return result, insertVersion(data), nil
+	}
+
+	var mapping yaml.Node
+	if err := mapping.Encode(settings); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	// Keep version first, the other settings follow in key order
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "version" {
+			ordered := []*yaml.Node{mapping.Content[i], mapping.Content[i+1]}
+			ordered = append(ordered, mapping.Content[:i]...)
+			mapping.Content = append(ordered, mapping.Content[i+2:]...)
+			break
+		}
+	}
+
+	out, err := encodeYAML(&mapping)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, out, nil
+}
+
+// insertVersion adds the version field after the leading comments of data,
+// leaving the rest of the file untouched
+func insertVersion(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	at := 0
+	for at < len(lines) {
+		line := bytes.TrimSpace(lines[at])
+		if len(line) > 0 && line[0] != '#' {
+			break
+		}
+		at++
+	}
+
+	var out bytes.Buffer
+	for _, line := range lines[:at] {
+		out.Write(line)
+	}
+	fmt.Fprintf(&out, "version: %d\n", CurrentVersion)
+	if at > 0 && len(bytes.TrimSpace(lines[at-1])) > 0 {
+		// Separate the version from the first section like the leading comments
+		out.WriteString("\n")
+	}
+	for _, line := range lines[at:] {
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
+// MigrateFile upgrades the configuration file at path to CurrentVersion. The original
+// is copied next to it first; the backup path is empty when the file was already current.
+func MigrateFile(path string) (*MigrationResult, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config: %w", err)
+	}
+
+	result, out, err := MigrateData(path, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if bytes.Equal(out, data) {
+		return result, "", nil
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		return nil, "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := writeFileAtomic(path, out, info.Mode().Perm()); err != nil {
+		return nil, "", err
+	}
+	return result, backup, nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateFlatLegacyLayout(t *testing.T) {
+	settings := map[string]interface{}{
+		"usetls":      true,
+		"tlscafile":   "/etc/ca.pem",
+		"serverhost":  "relay.example.com",
+		"serverport":  9443,
+		"jwttoken":    "token",
+		"localport":   3389,
+		"max_retries": 5,
+	}
+
+	result, err := Migrate(settings)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if result.From != 0 || result.To != CurrentVersion || len(result.Notes) != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	expected := map[string]interface{}{
+		"relay.host":                "relay.example.com",
+		"relay.port":                9443,
+		"relay.tls.enabled":         true,
+		"relay.tls.ca_cert":         "/etc/ca.pem",
+		"auth.secret":               "token",
+		"auth.type":                 "jwt",
+		"rate_limiting.max_retries": 5,
+		"version":                   CurrentVersion,
+	}
+	for key, want := range expected {
+		if got := lookupSetting(settings, key); got != want {
+			t.Errorf("%s: expected %v, got %v", key, want, got)
+		}
+	}
+	if _, ok := settings["localport"]; ok {
+		t.Error("legacy key was not removed")
+	}
+}
+
+func TestMigrateServerTunnelLayout(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", "config.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read legacy config: %v", err)
+	}
+	path := writeConfig(t, string(data))
+
+	result, backup, err := MigrateFile(path)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if !result.Changed() || backup == "" {
+		t.Fatalf("expected a migration with backup, got %+v %q", result, backup)
+	}
+	if original, err := os.ReadFile(backup); err != nil || string(original) != string(data) {
+		t.Errorf("backup does not contain the original file: %v", err)
+	}
+
+	// The referenced certificate files do not exist here, so only read the settings
+	cfg, err := NewLoader(path).Read()
+	if err != nil {
+		t.Fatalf("failed to read migrated config: %v", err)
+	}
+	if cfg.Version != CurrentVersion || cfg.Relay.Host != "edge.2gc.ru" || cfg.Auth.Secret != "your-jwt-token" {
+		t.Errorf("unexpected migrated config: %+v", cfg)
+	}
+	if cfg.Metrics.PrometheusPort != 9090 || cfg.Relay.TLS.ClientCert != "/etc/cloudbridge/certs/client.crt" {
+		t.Errorf("nested legacy settings were not moved: %+v", cfg)
+	}
+
+	// A current file is left alone
+	if _, backup, err := MigrateFile(path); err != nil || backup != "" {
+		t.Errorf("expected no second migration, got %q, %v", backup, err)
+	}
+}
+
+func TestLoaderMigratesLegacyLayoutInMemory(t *testing.T) {
+	path := writeConfig(t, "server:\n  host: legacy.example.com\n  jwt_token: t\n")
+
+	cfg, err := NewLoader(path).Load()
+	if err != nil {
+		t.Fatalf("failed to load legacy config: %v", err)
+	}
+	if cfg.Relay.Host != "legacy.example.com" || cfg.Auth.Secret != "t" {
+		t.Errorf("unexpected config %+v", cfg.Relay)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(data), "server:") {
+		t.Errorf("loading must not rewrite the file: %v", err)
+	}
+}
+
+func TestMigrateDataAddsVersionKeepingComments(t *testing.T) {
+	data := []byte("# header\n\nrelay:\n  host: a # inline\n")
+
+	result, out, err := MigrateData("config.yaml", data)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if result.Changed() {
+		t.Errorf("current layout should not be migrated: %+v", result)
+	}
+	if want := "# header\n\nversion: 1\nrelay:\n  host: a # inline\n"; string(out) != want {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestMigrateRejectsNewerVersion(t *testing.T) {
+	if _, err := Migrate(map[string]interface{}{"version": CurrentVersion + 1}); err == nil {
+		t.Error("expected an error for a newer config version")
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	stderrors "errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCertificatePinMismatch is returned when no certificate in the relay chain matches a configured pin
+var ErrCertificatePinMismatch = stderrors.New("relay certificate pin mismatch")
+
+// SPKIPin returns the base64 SHA-256 hash of the certificate's SubjectPublicKeyInfo
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// validatePins checks that every pin is a base64 encoded SHA-256 hash
+func validatePins(pins []string) error {
+	for _, pin := range pins {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil {
+			return fmt.Errorf("invalid pin %q: %w", pin, err)
+		}
+		if len(raw) != sha256.Size {
+			return fmt.Errorf("invalid pin %q: expected %d byte SHA-256 hash", pin, sha256.Size)
+		}
+	}
+	return nil
+}
+
+// pinVerifier returns a VerifyConnection callback that accepts the connection when a
+// certificate of a verified chain matches one of the pins. The certificates the relay sent
+// are not trusted as such, since anyone can append a public pinned certificate to them;
+// without verified chains (verify_cert disabled) only the leaf is compared.
+func pinVerifier(pins []string) func(tls.ConnectionState) error {
+	allowed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		allowed[strings.TrimPrefix(pin, "sha256/")] = true
+	}
+
+	return func(cs tls.ConnectionState) error {
+		var candidates []*x509.Certificate
+		for _, chain := range cs.VerifiedChains {
+			candidates = append(candidates, chain...)
+		}
+		if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+			candidates = cs.PeerCertificates[:1]
+		}
+
+		presented := make([]string, 0, len(candidates))
+		seen := make(map[string]bool, len(candidates))
+		for _, cert := range candidates {
+			pin := SPKIPin(cert)
+			if allowed[pin] {
+				return nil
+			}
+			if !seen[pin] {
+				seen[pin] = true
+				presented = append(presented, pin)
+			}
+		}
+		return fmt.Errorf("%w: presented SPKI hashes %s match none of the configured pins",
+			ErrCertificatePinMismatch, strings.Join(presented, ", "))
+	}
+}
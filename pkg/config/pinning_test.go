@@ -0,0 +1,84 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	stderrors "errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestPinVerifier(t *testing.T) {
+	cert := testCertificate(t)
+	other := testCertificate(t)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	if err := validatePins([]string{SPKIPin(cert), "sha256/" + SPKIPin(other)}); err != nil {
+		t.Fatalf("expected valid pins, got %v", err)
+	}
+	if err := validatePins([]string{"not-a-pin"}); err == nil {
+		t.Error("expected invalid pin to be rejected")
+	}
+
+	if err := pinVerifier([]string{SPKIPin(other), "sha256/" + SPKIPin(cert)})(state); err != nil {
+		t.Errorf("expected backup pin to match, got %v", err)
+	}
+	err := pinVerifier([]string{SPKIPin(other)})(state)
+	if !stderrors.Is(err, ErrCertificatePinMismatch) {
+		t.Errorf("expected ErrCertificatePinMismatch, got %v", err)
+	}
+}
+
+func TestPinVerifierIgnoresUnverifiedCertificates(t *testing.T) {
+	pinned := testCertificate(t)
+	attacker := testCertificate(t)
+	attackerCA := testCertificate(t)
+	pins := []string{SPKIPin(pinned)}
+
+	// The public pinned certificate appended to an untrusted chain without verification
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{attacker, pinned}}
+	if err := pinVerifier(pins)(state); !stderrors.Is(err, ErrCertificatePinMismatch) {
+		t.Errorf("expected appended certificate to be ignored, got %v", err)
+	}
+
+	// ... and with a verified chain that does not contain it
+	state.VerifiedChains = [][]*x509.Certificate{{attacker, attackerCA}}
+	if err := pinVerifier(pins)(state); !stderrors.Is(err, ErrCertificatePinMismatch) {
+		t.Errorf("expected certificate outside the verified chain to be ignored, got %v", err)
+	}
+
+	// Pins match any certificate of a verified chain, such as an intermediate
+	state.VerifiedChains = [][]*x509.Certificate{{attacker, pinned}}
+	if err := pinVerifier(pins)(state); err != nil {
+		t.Errorf("expected verified chain certificate to match, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import "strings"
+
+// Redacted replaces secret values in printed configurations
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are setting names whose values are never printed
+var sensitiveKeys = map[string]bool{
+	"secret":        true,
+	"token":         true,
+	"password":      true,
+	"passphrase":    true,
+	"client_secret": true,
+	"private_key":   true,
+	"access_token":  true,
+	"refresh_token": true,
+}
+
+// IsSensitiveKey reports whether the value of the dotted key must be redacted
+func IsSensitiveKey(key string) bool {
+	name := strings.ToLower(key)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return sensitiveKeys[name] || strings.HasSuffix(name, "_secret") || strings.HasSuffix(name, "_token")
+}
+
+// Redact returns a copy of settings with every sensitive value replaced
+func Redact(settings map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if IsSensitiveKey(key) {
+			if value != nil && value != "" {
+				value = Redacted
+			}
+			redacted[key] = value
+			continue
+		}
+		redacted[key] = redactValue(value)
+	}
+	return redacted
+}
+
+// redactValue redacts nested maps and lists
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return Redact(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = redactValue(item)
+		}
+		return items
+	default:
+		return value
+	}
+}
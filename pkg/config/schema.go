@@ -0,0 +1,485 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+	"github.com/spf13/viper"
+)
+
+// SchemaURL is the JSON Schema dialect of the generated schema
+const SchemaURL = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches Go durations such as 30s, 1h30m or 0
+const durationPattern = `^-?(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+// Schema is a JSON Schema document or subschema
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// schemaHint holds the constraints of a setting that cannot be derived from its Go type
+type schemaHint struct {
+	description      string
+	enum             []interface{}
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	required         bool
+}
+
+// bound returns a pointer for schema limits
+func bound(v float64) *float64 {
+	return &v
+}
+
+// portHint describes a TCP port setting
+func portHint(description string, required bool) schemaHint {
+	return schemaHint{description: description, minimum: bound(1), maximum: bound(65535), required: required}
+}
+
+// schemaHints are keyed by Go type name and setting name, so nested types such as
+// RelayConfig share their constraints wherever they appear
+var schemaHints = map[string]schemaHint{
+	"Config.version": {
+		description: "Configuration format version, older files are migrated on load",
+		minimum:     bound(0),
+		maximum:     bound(CurrentVersion),
+	},
+	"Config.relay":           {description: "Relay server connection"},
+	"Config.auth":            {description: "Authentication against the relay"},
+	"Config.rate_limiting":   {description: "Retry policy for rate limited requests"},
+	"Config.logging":         {description: "Log output"},
+	"Config.metrics":         {description: "Prometheus metrics endpoint"},
+	"Config.performance":     {description: "Runtime performance tuning"},
+	"Config.connections":     {description: "Connection admission control across all tunnels"},
+	"Config.encryption":      {description: "Encrypted configuration values"},
+	"Config.current_context": {description: "Name of the context applied over relay, auth and tunnels"},
+	"Config.contexts":        {description: "Named bundles of relay, auth and tunnel settings"},
+	"Config.tunnels":         {description: "Tunnels created on startup and reconciled on reload"},
+	"Config.identities":      {description: "Identities run side by side, each with its own relay connection and tunnels"},
+
+	"EncryptionConfig.key_file": {description: "Key file for enc:v1 values, config.key next to the configuration file when empty"},
+
+	"ContextConfig.name":        {description: "Context name used by current_context and --context", required: true},
+	"IdentityConfig.name":       {description: "Unique identity name", required: true},
+	"IdentityConfig.token":      {description: "Token of this identity"},
+	"IdentityConfig.token_file": {description: "File containing the token of this identity"},
+
+	"TunnelConfig.id":                      {description: "Unique tunnel identifier", required: true},
+	"TunnelConfig.enabled":                 {description: "Create the tunnel, defaults to true"},
+	"TunnelConfig.bind":                    {description: "Local address to listen on: an IP such as 127.0.0.1 or [::1], 0.0.0.0 for all interfaces, or unix:///path.sock; loopback when empty"},
+	"TunnelConfig.socket":                  {description: "File mode and owner of a unix:// listener"},
+	"TunnelConfig.local_port":              portHint("Local port to listen on, required unless bind is a unix socket", false),
+	"TunnelConfig.remote_host":             {description: "Host the relay connects to", required: true},
+	"TunnelConfig.remote_port":             portHint("Port the relay connects to", true),
+	"TunnelConfig.protocol":                {description: "Tunnel protocol", enum: []interface{}{types.TunnelProtocolTCP}},
+	"TunnelConfig.access":                  {description: "Source address allow and deny lists, checked for every new connection"},
+	"TunnelConfig.labels":                  {description: "Free-form labels sent to the relay"},
+	"TunnelConfig.drain_timeout":           {description: "How long active connections may finish when the tunnel is stopped, 0 for 30s"},
+	"TunnelConfig.idle_timeout":            {description: "Close connections without traffic in either direction for this long, 0 to disable"},
+	"TunnelConfig.max_connection_lifetime": {description: "Close connections open for this long, 0 to disable"},
+
+	"AccessConfig.allow": {description: "CIDRs or addresses that may connect; when set, other sources are rejected"},
+	"AccessConfig.deny":  {description: "CIDRs or addresses that are rejected, even when allowed"},
+
+	"SocketConfig.mode":  {description: "Octal file mode of the socket such as \"0660\", 0600 when empty"},
+	"SocketConfig.owner": {description: "User name or ID owning the socket"},
+	"SocketConfig.group": {description: "Group name or ID owning the socket"},
+
+	"TunnelLimitsConfig.max_connections": {description: "Maximum concurrent connections, 0 for unlimited", minimum: bound(0)},
+	"TunnelLimitsConfig.on_limit": {
+		description: "What happens to new connections at the limit, defaults to connections.on_limit",
+		enum:        []interface{}{types.OnLimitReject, types.OnLimitQueue},
+	},
+	"TunnelLimitsConfig.max_queue":            {description: "Maximum connections waiting for a slot, defaults to connections.max_queue", minimum: bound(0)},
+	"TunnelLimitsConfig.queue_timeout":        {description: "How long a queued connection waits for a slot, defaults to connections.queue_timeout"},
+	"TunnelLimitsConfig.bandwidth":            {description: "Bandwidth limits of the whole tunnel"},
+	"TunnelLimitsConfig.connection_bandwidth": {description: "Bandwidth limits of each connection"},
+
+	"BandwidthConfig.upload":         {description: "Bytes per second from local clients to the remote host, 0 for unlimited", minimum: bound(0)},
+	"BandwidthConfig.download":       {description: "Bytes per second from the remote host to local clients, 0 for unlimited", minimum: bound(0)},
+	"BandwidthConfig.upload_burst":   {description: "Upload bytes that may pass at once, 0 for one second of traffic", minimum: bound(0)},
+	"BandwidthConfig.download_burst": {description: "Download bytes that may pass at once, 0 for one second of traffic", minimum: bound(0)},
+
+	"ConnectionsConfig.max_connections": {description: "Maximum concurrent connections of all tunnels together, 0 for unlimited", minimum: bound(0)},
+	"ConnectionsConfig.on_limit": {
+		description: "What happens to new connections at a limit: reject closes them, queue holds them until a slot is free",
+		enum:        []interface{}{types.OnLimitReject, types.OnLimitQueue},
+	},
+	"ConnectionsConfig.queue_timeout": {description: "How long a queued connection waits for a slot before it is rejected"},
+	"ConnectionsConfig.max_queue":     {description: "Maximum connections waiting for a slot per tunnel; further ones are rejected", minimum: bound(0)},
+
+	"RelayConfig.host":    {description: "Relay server hostname"},
+	"RelayConfig.port":    portHint("Relay server port", false),
+	"RelayConfig.timeout": {description: "Connection timeout"},
+
+	"TLSConfig.enabled": {description: "Connect to the relay over TLS"},
+	"TLSConfig.profile": {
+		description: "TLS policy profile",
+		enum:        []interface{}{TLSProfileModern, TLSProfileIntermediate, TLSProfileCustom},
+	},
+	"TLSConfig.min_version":      {description: "Minimum TLS version of the custom profile", enum: []interface{}{"1.2", "1.3"}},
+	"TLSConfig.cipher_suites":    {description: "Cipher suites of the custom profile"},
+	"TLSConfig.curves":           {description: "Key exchange curves of the custom profile"},
+	"TLSConfig.alpn":             {description: "ALPN protocols offered to the relay"},
+	"TLSConfig.verify_cert":      {description: "Verify the relay certificate"},
+	"TLSConfig.ca_cert":          {description: "CA certificate file used to verify the relay"},
+	"TLSConfig.client_cert":      {description: "Client certificate file, reloaded when it changes"},
+	"TLSConfig.client_key":       {description: "Client private key file"},
+	"TLSConfig.server_name":      {description: "TLS server name, defaults to relay.host"},
+	"TLSConfig.pins":             {description: "Base64 SHA-256 SPKI pins of the relay certificate chain"},
+	"TLSConfig.backup_pins":      {description: "Additional accepted pins for key rotation"},
+	"TLSConfig.cert_warn_before": {description: "Warn this long before the client certificate expires"},
+
+	"EnrollmentConfig.url":            {description: "Enrollment endpoint receiving certificate signing requests"},
+	"EnrollmentConfig.renew_before":   {description: "Renew this long before expiry, 0 renews after two thirds of the lifetime"},
+	"EnrollmentConfig.renew_disabled": {description: "Turn off automatic certificate renewal"},
+
+	"AuthConfig.type": {
+		description: "Authentication method",
+		enum:        []interface{}{"jwt", "keycloak", "oidc", "mtls"},
+	},
+	"AuthConfig.secret": {description: "JWT secret or token"},
+
+	"KeycloakConfig.enabled":    {description: "Validate tokens against Keycloak"},
+	"KeycloakConfig.server_url": {description: "Keycloak server URL"},
+	"KeycloakConfig.realm":      {description: "Keycloak realm"},
+	"KeycloakConfig.client_id":  {description: "Keycloak client ID"},
+	"KeycloakConfig.jwks_url":   {description: "JWKS URL, derived from the realm when empty"},
+
+	"OIDCConfig.issuer_url": {description: "OpenID Connect issuer URL"},
+	"OIDCConfig.jwks_url":   {description: "JWKS URL, discovered from the issuer when empty"},
+
+	"MTLSConfig.subject_source": {
+		description: "Certificate field used as the subject",
+		enum:        []interface{}{"cn", "san_uri", "san_dns", "san_email"},
+	},
+	"MTLSConfig.tenant_source": {
+		description: "Certificate field used as the tenant",
+		enum:        []interface{}{"ou", "o", "san_uri", "none"},
+	},
+
+	"ValidationConfig.issuer":          {description: "Expected token issuer"},
+	"ValidationConfig.audience":        {description: "Accepted token audiences"},
+	"ValidationConfig.required_claims": {description: "Claims every token must carry"},
+	"ValidationConfig.leeway":          {description: "Allowed clock skew"},
+	"ValidationConfig.max_token_age":   {description: "Maximum token age, 0 for unlimited"},
+
+	"CredentialStoreConfig.enabled":  {description: "Cache tokens in the encrypted credential store"},
+	"CredentialStoreConfig.path":     {description: "Credential store file"},
+	"CredentialStoreConfig.key_file": {description: "File with the credential store passphrase"},
+	"CredentialStoreConfig.identity": {description: "Credential store entry to use"},
+
+	"RateLimitingConfig.enabled":            {description: "Retry rate limited requests with backoff"},
+	"RateLimitingConfig.max_retries":        {description: "Maximum retry attempts", minimum: bound(0)},
+	"RateLimitingConfig.backoff_multiplier": {description: "Exponential backoff multiplier", exclusiveMinimum: bound(0)},
+	"RateLimitingConfig.max_backoff":        {description: "Maximum backoff between retries"},
+
+	"LoggingConfig.level":  {description: "Log level", enum: []interface{}{"debug", "info", "warn", "error"}},
+	"LoggingConfig.format": {description: "Log format", enum: []interface{}{"json", "text"}},
+	"LoggingConfig.output": {description: "stdout, stderr or a file path"},
+
+	"MetricsConfig.enabled":            {description: "Serve Prometheus metrics"},
+	"MetricsConfig.prometheus_port":    portHint("Port of the Prometheus endpoint", false),
+	"MetricsConfig.tenant_metrics":     {description: "Export per-tenant metrics"},
+	"MetricsConfig.buffer_metrics":     {description: "Export buffer pool metrics"},
+	"MetricsConfig.connection_metrics": {description: "Export connection metrics"},
+
+	"PerformanceConfig.enabled": {description: "Apply runtime performance tuning"},
+	"PerformanceConfig.optimization_mode": {
+		description: "Runtime tuning preset",
+		enum:        []interface{}{"high_throughput", "low_latency"},
+	},
+	"PerformanceConfig.gc_percent":     {description: "Go garbage collector target percentage, -1 disables collection", minimum: bound(-1)},
+	"PerformanceConfig.memory_ballast": {description: "Allocate a memory ballast to reduce collections"},
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configSchema returns the schema used to validate loaded settings
+var configSchema = sync.OnceValue(GenerateSchema)
+
+// GenerateSchema returns the JSON Schema of the configuration file derived from types.Config
+func GenerateSchema() *Schema {
+	schema := schemaForType(reflect.TypeOf(types.Config{}))
+	schema.Schema = SchemaURL
+	schema.Title = "CloudBridge client configuration"
+
+	// Defaults only apply to the top-level settings
+	v := viper.New()
+	setDefaults(v)
+	for _, key := range v.AllKeys() {
+		if property := schema.lookup(key); property != nil {
+			property.Default = v.Get(key)
+		}
+	}
+
+	return schema
+}
+
+// MarshalIndent encodes the schema as indented JSON
+func (s *Schema) MarshalIndent() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// lookup returns the property at a dotted key path
+func (s *Schema) lookup(key string) *Schema {
+	current := s
+	for _, name := range strings.Split(key, ".") {
+		current = current.Properties[name]
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// schemaForType builds the schema of a Go type
+func schemaForType(t reflect.Type) *Schema {
+	if t == durationType {
+		return &Schema{Type: "string", Pattern: durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaForType(t.Elem())}
+	case reflect.Struct:
+		return schemaForStruct(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// schemaForStruct builds an object schema from the mapstructure fields of t
+func schemaForStruct(t reflect.Type) *Schema {
+	schema := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		property := schemaForType(field.Type)
+		if hint, ok := schemaHints[t.Name()+"."+name]; ok {
+			property.Description = hint.description
+			property.Enum = hint.enum
+			property.Minimum = hint.minimum
+			property.Maximum = hint.maximum
+			property.ExclusiveMinimum = hint.exclusiveMinimum
+			if hint.required {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		schema.Properties[name] = property
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// ValidateSettings checks raw settings, as read from the file, environment and flags,
+// against the schema and returns a problem for every mismatching value
+func ValidateSettings(schema *Schema, settings map[string]interface{}) []Problem {
+	var p problems
+	schema.validate(&p, "", settings)
+	return p
+}
+
+// validate checks value against s and records problems at path
+func (s *Schema) validate(p *problems, path string, value interface{}) {
+	if value == nil {
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		s.validateObject(p, path, value)
+		return
+	case "array":
+		s.validateArray(p, path, value)
+		return
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			p.add(path, "must be a string")
+			return
+		}
+		if s.Pattern == durationPattern && !IsSecretRef(text) {
+			if _, err := time.ParseDuration(text); err != nil {
+				p.add(path, "must be a duration such as 30s or 5m")
+				return
+			}
+		}
+	case "integer", "number":
+		number, ok := toNumber(value)
+		if !ok || (s.Type == "integer" && number != float64(int64(number))) {
+			p.add(path, "must be %s", map[string]string{"integer": "an integer", "number": "a number"}[s.Type])
+			return
+		}
+		switch {
+		case s.Minimum != nil && number < *s.Minimum:
+			p.add(path, "must be at least %v", *s.Minimum)
+			return
+		case s.Maximum != nil && number > *s.Maximum:
+			p.add(path, "must be at most %v", *s.Maximum)
+			return
+		case s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum:
+			p.add(path, "must be greater than %v", *s.ExclusiveMinimum)
+			return
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				p.add(path, "must be true or false")
+				return
+			}
+		default:
+			p.add(path, "must be true or false")
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 && !s.allows(value) {
+		allowed := make([]string, len(s.Enum))
+		for i, option := range s.Enum {
+			allowed[i] = fmt.Sprintf("%q", fmt.Sprint(option))
+		}
+		p.add(path, "must be one of %s, got %q", strings.Join(allowed, ", "), fmt.Sprint(value))
+	}
+}
+
+// validateObject checks known properties, required properties and unknown keys
+func (s *Schema) validateObject(p *problems, path string, value interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		p.add(path, "must be a mapping")
+		return
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		if property, ok := s.Properties[key]; ok {
+			property.validate(p, keyPath, object[key])
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case *Schema:
+			extra.validate(p, keyPath, object[key])
+		case bool:
+			if !extra {
+				p.add(keyPath, "unknown setting")
+			}
+		}
+	}
+
+	for _, key := range s.Required {
+		if v, ok := object[key]; !ok || v == nil || v == "" {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			p.add(keyPath, "is required")
+		}
+	}
+}
+
+// validateArray checks every item of a list
+func (s *Schema) validateArray(p *problems, path string, value interface{}) {
+	switch items := value.(type) {
+	case []interface{}:
+		for i, item := range items {
+			s.Items.validate(p, fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	case []string:
+		for i, item := range items {
+			s.Items.validate(p, fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	case string:
+		// Lists of strings may be given as a comma separated environment variable
+		if s.Items.Type != "string" {
+			p.add(path, "must be a list")
+		}
+	default:
+		p.add(path, "must be a list")
+	}
+}
+
+// allows reports whether value is one of the enumerated options
+func (s *Schema) allows(value interface{}) bool {
+	for _, option := range s.Enum {
+		if fmt.Sprint(option) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// toNumber converts numbers and numeric strings from environment variables to float64
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestGenerateSchema(t *testing.T) {
+	schema := GenerateSchema()
+
+	port := schema.lookup("relay.port")
+	if port == nil || port.Type != "integer" || *port.Minimum != 1 || *port.Maximum != 65535 {
+		t.Fatalf("unexpected relay.port schema: %+v", port)
+	}
+	if port.Default != 8080 {
+		t.Errorf("expected relay.port default 8080, got %v", port.Default)
+	}
+	if mode := schema.lookup("performance.optimization_mode"); mode == nil || len(mode.Enum) != 2 {
+		t.Errorf("expected optimization_mode enum, got %+v", mode)
+	}
+
+	// local_port is optional because unix socket tunnels have none
+	tunnel := schema.Properties["tunnels"].Items
+	if tunnel == nil || len(tunnel.Required) != 3 {
+		t.Fatalf("expected required tunnel fields, got %+v", tunnel)
+	}
+	// Nested types share their constraints
+	identityPort := schema.Properties["identities"].Items.lookup("relay.port")
+	if identityPort == nil || identityPort.Maximum == nil {
+		t.Errorf("expected identity relay.port limits, got %+v", identityPort)
+	}
+
+	if _, err := schema.MarshalIndent(); err != nil {
+		t.Fatalf("failed to encode schema: %v", err)
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		path     string
+	}{
+		{"enum", `{"relay":{"tls":{"min_version":"1.0"}}}`, "relay.tls.min_version"},
+		{"type", `{"relay":{"port":"https"}}`, "relay.port"},
+		{"range", `{"metrics":{"prometheus_port":0}}`, "metrics.prometheus_port"},
+		{"exclusive minimum", `{"rate_limiting":{"backoff_multiplier":0}}`, "rate_limiting.backoff_multiplier"},
+		{"duration", `{"relay":{"timeout":"soon"}}`, "relay.timeout"},
+		{"unknown key", `{"relay":{"hots":"x"}}`, "relay.hots"},
+		{"required in list", `{"tunnels":[{"id":"a","local_port":1,"remote_port":1}]}`, "tunnels[0].remote_host"},
+		{"list item", `{"identities":[{"name":"a","relay":{"port":70000}}]}`, "identities[0].relay.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var settings map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.settings), &settings); err != nil {
+				t.Fatalf("invalid test settings: %v", err)
+			}
+			found := ValidateSettings(GenerateSchema(), settings)
+			if len(found) != 1 || found[0].Path != tt.path {
+				t.Errorf("expected one problem at %s, got %v", tt.path, found)
+			}
+		})
+	}
+}
+
+func TestValidateSettingsAcceptsEnvironmentStrings(t *testing.T) {
+	settings := map[string]interface{}{
+		"relay": map[string]interface{}{"port": "9443", "tls": map[string]interface{}{"enabled": "true", "alpn": "h2,http/1.1"}},
+	}
+	if found := ValidateSettings(GenerateSchema(), settings); len(found) != 0 {
+		t.Errorf("expected no problems, got %v", found)
+	}
+}
+
+func TestLoaderReportsSchemaProblems(t *testing.T) {
+	path := writeConfig(t, "relay:\n  port: 0\n  tls:\n    min_version: \"1.1\"\nauth:\n  secret: s\n")
+
+	_, err := NewLoader(path).Load()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+
+	paths := make(map[string]int)
+	for _, problem := range invalid.Problems {
+		paths[problem.Path]++
+	}
+	if paths["relay.port"] != 1 || paths["relay.tls.min_version"] != 1 {
+		t.Errorf("expected one problem at relay.port and relay.tls.min_version, got %v", invalid.Problems)
+	}
+}
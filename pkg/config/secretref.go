@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// secretExecTimeout bounds the run time of ${exec:...} commands
+const secretExecTimeout = 10 * time.Second
+
+// secretRefPattern matches ${file:path}, ${env:NAME} and ${exec:command} references
+var secretRefPattern = regexp.MustCompile(`\$\{(file|env|exec):([^}]*)\}`)
+
+// IsSecretRef reports whether value contains a secret reference
+func IsSecretRef(value string) bool {
+	return secretRefPattern.MatchString(value)
+}
+
+// resolveSecretRefs replaces secret references in every string setting of c.
+// Errors name the setting and the reference but never a resolved value.
+func resolveSecretRefs(c *types.Config) error {
+	return transformStrings(reflect.ValueOf(c).Elem(), "", resolveString)
+}
+
+// transformStrings walks v and replaces every string in structures, lists and maps with fn's result
+func transformStrings(v reflect.Value, path string, fn func(string) (string, error)) error {
+	return walkStrings(v, path, func(key, value string) (string, error) {
+		resolved, err := fn(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", key, err)
+		}
+		return resolved, nil
+	})
+}
+
+// walkStrings walks v and replaces every string in structures, lists and maps with fn's result,
+// passing fn the key of the setting. It stops at the first error.
+func walkStrings(v reflect.Value, path string, fn func(key, value string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		resolved, err := fn(path, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(resolved)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Tag.Get("mapstructure")
+			if name == "" || name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := walkStrings(v.Field(i), name, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			resolved, err := fn(fmt.Sprintf("%s.%v", path, iter.Key()), iter.Value().String())
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(resolved).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+// resolveString replaces every reference in value
+func resolveString(value string) (string, error) {
+	if !IsSecretRef(value) {
+		return value, nil
+	}
+
+	var resolveErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if resolveErr != nil {
+			return ""
+		}
+		match := secretRefPattern.FindStringSubmatch(ref)
+		secret, err := resolveSecretRef(match[1], match[2])
+		if err != nil {
+			resolveErr = fmt.Errorf("failed to resolve %s: %w", ref, err)
+			return ""
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+// resolveSecretRef returns the value a single reference points to
+func resolveSecretRef(kind, target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", fmt.Errorf("empty %s reference", kind)
+	}
+
+	switch kind {
+	case "file":
+		data, err := os.ReadFile(target)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "env":
+		value, ok := os.LookupEnv(target)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", target)
+		}
+		return value, nil
+	case "exec":
+		return runSecretCommand(target)
+	default:
+		return "", fmt.Errorf("unknown reference type %s", kind)
+	}
+}
+
+// runSecretCommand runs command without a shell and returns its trimmed standard output
+func runSecretCommand(command string) (string, error) {
+	args := strings.Fields(command)
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretExecTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // #nosec G204 -- command comes from the operator's configuration
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
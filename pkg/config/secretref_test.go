@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+func TestResolveSecretRefs(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "jwt")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	t.Setenv("TEST_TENANT_TOKEN", "from-env")
+
+	cfg := &types.Config{
+		Auth: types.AuthConfig{Secret: "${file:" + secretFile + "}"},
+		Identities: []types.IdentityConfig{
+			{Name: "a", Token: "Bearer ${env:TEST_TENANT_TOKEN}"},
+		},
+		Tunnels: []types.TunnelConfig{
+			{ID: "t", Labels: map[string]string{"owner": "${env:TEST_TENANT_TOKEN}"}},
+		},
+	}
+	if runtime.GOOS != "windows" {
+		cfg.Auth.Keycloak.ClientID = "${exec:echo from-exec}"
+	}
+
+	if err := resolveSecretRefs(cfg); err != nil {
+		t.Fatalf("failed to resolve references: %v", err)
+	}
+
+	if cfg.Auth.Secret != "from-file" {
+		t.Errorf("unexpected file secret %q", cfg.Auth.Secret)
+	}
+	if cfg.Identities[0].Token != "Bearer from-env" {
+		t.Errorf("unexpected env token %q", cfg.Identities[0].Token)
+	}
+	if cfg.Tunnels[0].Labels["owner"] != "from-env" {
+		t.Errorf("unexpected label %q", cfg.Tunnels[0].Labels["owner"])
+	}
+	if runtime.GOOS != "windows" && cfg.Auth.Keycloak.ClientID != "from-exec" {
+		t.Errorf("unexpected exec value %q", cfg.Auth.Keycloak.ClientID)
+	}
+}
+
+func TestResolveSecretRefsErrors(t *testing.T) {
+	cfg := &types.Config{Auth: types.AuthConfig{Secret: "${env:TEST_UNSET_SECRET_VARIABLE}"}}
+	err := resolveSecretRefs(cfg)
+	if err == nil || !strings.Contains(err.Error(), "auth.secret") {
+		t.Fatalf("expected error naming auth.secret, got %v", err)
+	}
+}
+
+func TestLoaderKeepsSecretRefsInSettings(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", "resolved-secret")
+	loader := NewLoader(writeConfig(t, "auth:\n  secret: ${env:TEST_JWT_SECRET}\n"))
+
+	cfg, err := loader.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Auth.Secret != "resolved-secret" {
+		t.Errorf("expected resolved secret, got %q", cfg.Auth.Secret)
+	}
+
+	settings := loader.Settings()
+	if secret := settings["auth"].(map[string]interface{})["secret"]; secret != "${env:TEST_JWT_SECRET}" {
+		t.Errorf("resolved secret leaked into settings: %v", secret)
+	}
+}
+
+func TestLoaderReadUnresolved(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("touch is not available on windows")
+	}
+	marker := filepath.Join(t.TempDir(), "executed")
+	loader := NewLoader(writeConfig(t, "auth:\n  secret: ${exec:touch "+marker+"}\n"+
+		"relay:\n  tls:\n    server_name: enc:v1:not-decryptable\n"))
+
+	cfg, err := loader.ReadUnresolved()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("expected ReadUnresolved not to run the exec reference")
+	}
+	if cfg.Auth.Secret != "${exec:touch "+marker+"}" || cfg.Relay.TLS.ServerName != "enc:v1:not-decryptable" {
+		t.Errorf("expected values as written, got %q and %q", cfg.Auth.Secret, cfg.Relay.TLS.ServerName)
+	}
+
+	// Reading for use decrypts, which fails without a key
+	if _, err := loader.Read(); err == nil {
+		t.Error("expected Read to fail on the undecryptable value")
+	}
+}
+
+func TestLoaderCheckReportsSecretProblems(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t, filepath.Join(dir, DefaultKeyFileName))
+	other, err := GenerateConfigKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	foreign, err := other.Encrypt("from-other-key")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	encrypted, err := key.Encrypt("from-key")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	content := "auth:\n  secret: ${env:TEST_UNSET_SECRET_VARIABLE}\n" +
+		"  keycloak:\n    client_id: " + encrypted + "\n" +
+		"relay:\n  port: 70000\n  tls:\n    server_name: " + foreign + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, found, err := NewLoader(path).Check()
+	if err != nil {
+		t.Fatalf("expected problems instead of an error, got %v", err)
+	}
+	reported := make(map[string]string)
+	for _, problem := range found {
+		reported[problem.Path] = problem.Message
+	}
+	for _, key := range []string{"auth.secret", "relay.tls.server_name", "relay.port"} {
+		if _, ok := reported[key]; !ok {
+			t.Errorf("expected a problem at %s, got %v", key, found)
+		}
+	}
+	if !strings.Contains(reported["auth.secret"], "TEST_UNSET_SECRET_VARIABLE is not set") {
+		t.Errorf("unexpected secret problem %q", reported["auth.secret"])
+	}
+	if cfg.Auth.Keycloak.ClientID != "from-key" {
+		t.Errorf("expected the other values to be decrypted, got %q", cfg.Auth.Keycloak.ClientID)
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// TLS policy profiles
+const (
+	TLSProfileModern       = "modern"
+	TLSProfileIntermediate = "intermediate"
+	TLSProfileCustom       = "custom"
+)
+
+// tlsProfile is the resolved TLS policy applied to the relay connection
+type tlsProfile struct {
+	name         string
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+// weak reports whether the profile allows anything below the modern profile
+func (p *tlsProfile) weak() bool {
+	return p.minVersion < tls.VersionTLS13
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p521":   tls.CurveP521,
+}
+
+var defaultCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+
+// modernCipherSuites are the TLS 1.3 suites; Go does not allow restricting them
+var modernCipherSuites = []uint16{
+	tls.TLS_AES_256_GCM_SHA384,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
+	tls.TLS_AES_128_GCM_SHA256,
+}
+
+// intermediateCipherSuites add forward secret AEAD suites for TLS 1.2 peers
+var intermediateCipherSuites = append([]uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}, modernCipherSuites...)
+
+// resolveTLSProfile validates the TLS settings and returns the policy they describe
+func resolveTLSProfile(c types.TLSConfig) (*tlsProfile, error) {
+	name := c.Profile
+	if name == "" {
+		name = TLSProfileModern
+	}
+
+	var profile *tlsProfile
+	switch name {
+	case TLSProfileModern:
+		profile = &tlsProfile{name: name, minVersion: tls.VersionTLS13, cipherSuites: modernCipherSuites, curves: defaultCurves}
+	case TLSProfileIntermediate:
+		profile = &tlsProfile{name: name, minVersion: tls.VersionTLS12, cipherSuites: intermediateCipherSuites, curves: defaultCurves}
+	case TLSProfileCustom:
+		return resolveCustomTLSProfile(c)
+	default:
+		return nil, fmt.Errorf("unknown TLS profile %q (expected %s, %s or %s)",
+			name, TLSProfileModern, TLSProfileIntermediate, TLSProfileCustom)
+	}
+
+	// Named profiles fix their parameters; min_version may only restate the profile minimum
+	if c.MinVersion != "" && tlsVersions[c.MinVersion] != profile.minVersion {
+		return nil, fmt.Errorf("min_version %q conflicts with the %s profile, use the custom profile instead", c.MinVersion, name)
+	}
+	if len(c.CipherSuites) > 0 || len(c.Curves) > 0 {
+		return nil, fmt.Errorf("cipher_suites and curves require the custom profile")
+	}
+
+	return profile, nil
+}
+
+// resolveCustomTLSProfile builds a policy from explicitly configured parameters
+func resolveCustomTLSProfile(c types.TLSConfig) (*tlsProfile, error) {
+	profile := &tlsProfile{name: TLSProfileCustom, curves: defaultCurves}
+
+	minVersion, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("custom TLS profile requires min_version \"1.2\" or \"1.3\", got %q", c.MinVersion)
+	}
+	profile.minVersion = minVersion
+
+	if len(c.CipherSuites) == 0 {
+		profile.cipherSuites = intermediateCipherSuites
+	} else {
+		secure := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.Name] = suite.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := secure[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported or insecure cipher suite %q", name)
+			}
+			profile.cipherSuites = append(profile.cipherSuites, id)
+		}
+	}
+
+	if len(c.Curves) > 0 {
+		profile.curves = nil
+		for _, name := range c.Curves {
+			curve, ok := tlsCurves[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unsupported curve %q (expected X25519, P256, P384 or P521)", name)
+			}
+			profile.curves = append(profile.curves, curve)
+		}
+	}
+
+	return profile, nil
+}
+
+// validateALPN checks the configured application protocols
+func validateALPN(protocols []string) error {
+	for _, protocol := range protocols {
+		if protocol == "" || len(protocol) > 255 {
+			return fmt.Errorf("invalid ALPN protocol %q", protocol)
+		}
+	}
+	return nil
+}
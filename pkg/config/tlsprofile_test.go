@@ -0,0 +1,54 @@
+package config
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+func TestResolveTLSProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     types.TLSConfig
+		minVersion uint16
+		wantErr    bool
+	}{
+		{"default is modern", types.TLSConfig{}, tls.VersionTLS13, false},
+		{"legacy min_version", types.TLSConfig{MinVersion: "1.3"}, tls.VersionTLS13, false},
+		{"intermediate", types.TLSConfig{Profile: TLSProfileIntermediate}, tls.VersionTLS12, false},
+		{"modern conflicting version", types.TLSConfig{Profile: TLSProfileModern, MinVersion: "1.2"}, 0, true},
+		{"named profile with suites", types.TLSConfig{Profile: TLSProfileIntermediate, Curves: []string{"P256"}}, 0, true},
+		{"custom", types.TLSConfig{
+			Profile:      TLSProfileCustom,
+			MinVersion:   "1.2",
+			CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			Curves:       []string{"X25519"},
+		}, tls.VersionTLS12, false},
+		{"custom without version", types.TLSConfig{Profile: TLSProfileCustom}, 0, true},
+		{"custom insecure suite", types.TLSConfig{
+			Profile:      TLSProfileCustom,
+			MinVersion:   "1.2",
+			CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		}, 0, true},
+		{"unknown profile", types.TLSConfig{Profile: "legacy"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := resolveTLSProfile(tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if profile.minVersion != tt.minVersion {
+				t.Errorf("expected min version %x, got %x", tt.minVersion, profile.minVersion)
+			}
+		})
+	}
+}
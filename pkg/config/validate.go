@@ -0,0 +1,350 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// Problem is a single configuration error at a dotted key path
+type Problem struct {
+	Path    string
+	Message string
+}
+
+// String formats the problem as "path: message"
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError reports every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error joins all problems into one message
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// problems collects configuration problems
+type problems []Problem
+
+// add records a problem at path
+func (p *problems) add(path, format string, args ...interface{}) {
+	*p = append(*p, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// mergeProblems appends the problems of second whose path is not already reported in first
+func mergeProblems(first, second []Problem) []Problem {
+	reported := make(map[string]bool, len(first))
+	for _, problem := range first {
+		reported[problem.Path] = true
+	}
+	for _, problem := range second {
+		if !reported[problem.Path] {
+			first = append(first, problem)
+		}
+	}
+	return first
+}
+
+// Validate returns every problem in the configuration
+func Validate(c *types.Config) []Problem {
+	var p problems
+
+	if c.Relay.Host == "" {
+		p.add("relay.host", "relay host is required")
+	}
+
+	if c.Relay.Port <= 0 || c.Relay.Port > 65535 {
+		p.add("relay.port", "invalid relay port")
+	}
+
+	if c.Relay.TLS.Enabled {
+		validateTLS(&p, c.Relay.TLS)
+	}
+
+	validateAuth(&p, c)
+
+	validateTunnels(&p, "tunnels", c.Tunnels)
+
+	names := make(map[string]bool)
+	for i, identity := range c.Identities {
+		path := fmt.Sprintf("identities[%d]", i)
+		if identity.Name == "" {
+			p.add(path+".name", "name is required")
+		} else if names[identity.Name] {
+			p.add(path+".name", "duplicate identity name %s", identity.Name)
+		}
+		names[identity.Name] = true
+
+		if identity.Token != "" && identity.TokenFile != "" {
+			p.add(path, "token and token_file are mutually exclusive")
+		}
+		if identity.Relay.Port < 0 || identity.Relay.Port > 65535 {
+			p.add(path+".relay.port", "invalid relay port")
+		}
+		validateTunnels(&p, path+".tunnels", identity.Tunnels)
+	}
+
+	validateContexts(&p, c)
+
+	if c.RateLimiting.MaxRetries < 0 {
+		p.add("rate_limiting.max_retries", "max retries cannot be negative")
+	}
+
+	if c.RateLimiting.BackoffMultiplier <= 0 {
+		p.add("rate_limiting.backoff_multiplier", "backoff multiplier must be positive")
+	}
+
+	if c.Connections.MaxConnections < 0 {
+		p.add("connections.max_connections", "max connections cannot be negative")
+	}
+	validateAdmission(&p, "connections", c.Connections.OnLimit, c.Connections.QueueTimeout, c.Connections.MaxQueue)
+
+	return p
+}
+
+// validateAdmission validates the on_limit, queue_timeout and max_queue settings under path
+func validateAdmission(p *problems, path, onLimit string, queueTimeout time.Duration, maxQueue int) {
+	if onLimit != "" && onLimit != types.OnLimitReject && onLimit != types.OnLimitQueue {
+		p.add(path+".on_limit", "on_limit must be %s or %s", types.OnLimitReject, types.OnLimitQueue)
+	}
+	if queueTimeout < 0 {
+		p.add(path+".queue_timeout", "queue timeout cannot be negative")
+	}
+	if maxQueue < 0 {
+		p.add(path+".max_queue", "max queue cannot be negative")
+	}
+}
+
+// validateContexts checks context names and the settings each context overrides
+func validateContexts(p *problems, c *types.Config) {
+	names := make(map[string]bool)
+	for i, ctx := range c.Contexts {
+		path := fmt.Sprintf("contexts[%d]", i)
+		if ctx.Name == "" {
+			p.add(path+".name", "name is required")
+		} else if names[ctx.Name] {
+			p.add(path+".name", "duplicate context name %s", ctx.Name)
+		}
+		names[ctx.Name] = true
+
+		if ctx.Relay.Port < 0 || ctx.Relay.Port > 65535 {
+			p.add(path+".relay.port", "invalid relay port")
+		}
+		validateTunnels(p, path+".tunnels", ctx.Tunnels)
+	}
+}
+
+// validateTLS validates the relay TLS settings
+func validateTLS(p *problems, c types.TLSConfig) {
+	if _, err := resolveTLSProfile(c); err != nil {
+		p.add("relay.tls.profile", "invalid TLS policy: %v", err)
+	}
+	if err := validateALPN(c.ALPN); err != nil {
+		p.add("relay.tls.alpn", "%v", err)
+	}
+
+	if c.CACert != "" {
+		if _, err := os.Stat(c.CACert); os.IsNotExist(err) {
+			p.add("relay.tls.ca_cert", "CA certificate file not found: %s", c.CACert)
+		}
+	}
+
+	if c.ClientCert != "" && c.ClientKey == "" {
+		p.add("relay.tls.client_key", "client key is required when client certificate is provided")
+	}
+	if c.ClientKey != "" && c.ClientCert == "" {
+		p.add("relay.tls.client_cert", "client certificate is required when client key is provided")
+	}
+
+	if err := validatePins(c.Pins); err != nil {
+		p.add("relay.tls.pins", "invalid relay TLS pins: %v", err)
+	}
+	if err := validatePins(c.BackupPins); err != nil {
+		p.add("relay.tls.backup_pins", "invalid relay TLS pins: %v", err)
+	}
+	if len(c.Pins) == 0 && len(c.BackupPins) > 0 {
+		p.add("relay.tls.backup_pins", "backup pins require at least one primary pin")
+	}
+
+	if enrollment := c.Enrollment; enrollment.URL != "" {
+		if !strings.HasPrefix(enrollment.URL, "https://") {
+			p.add("relay.tls.enrollment.url", "enrollment URL must use https")
+		}
+		if enrollment.RenewBefore < 0 {
+			p.add("relay.tls.enrollment.renew_before", "enrollment renew_before cannot be negative")
+		}
+	}
+}
+
+// validateAuth validates the authentication settings
+func validateAuth(p *problems, c *types.Config) {
+	if c.Auth.Type == "jwt" && c.Auth.Secret == "" {
+		p.add("auth.secret", "JWT secret is required for JWT authentication")
+	}
+
+	if c.Auth.Keycloak.Enabled {
+		if c.Auth.Keycloak.ServerURL == "" {
+			p.add("auth.keycloak.server_url", "keycloak server URL is required")
+		}
+		if c.Auth.Keycloak.Realm == "" {
+			p.add("auth.keycloak.realm", "keycloak realm is required")
+		}
+		if c.Auth.Keycloak.ClientID == "" {
+			p.add("auth.keycloak.client_id", "keycloak client ID is required")
+		}
+	}
+
+	if c.Auth.Type == "mtls" && (!c.Relay.TLS.Enabled || c.Relay.TLS.ClientCert == "") {
+		p.add("auth.type", "TLS with a client certificate is required for mTLS authentication")
+	}
+
+	if c.Auth.Type == "oidc" && c.Auth.OIDC.IssuerURL == "" {
+		p.add("auth.oidc.issuer_url", "OIDC issuer URL is required for OIDC authentication")
+	}
+
+	if c.Auth.Validation.Leeway < 0 {
+		p.add("auth.validation.leeway", "token validation leeway cannot be negative")
+	}
+
+	if c.Auth.Validation.MaxTokenAge < 0 {
+		p.add("auth.validation.max_token_age", "maximum token age cannot be negative")
+	}
+}
+
+// validateBandwidth validates the rates and bursts of a bandwidth limit under path
+func validateBandwidth(p *problems, path string, b types.BandwidthConfig) {
+	rates := []struct {
+		name  string
+		value int64
+	}{
+		{"upload", b.Upload},
+		{"download", b.Download},
+		{"upload_burst", b.UploadBurst},
+		{"download_burst", b.DownloadBurst},
+	}
+	for _, rate := range rates {
+		if rate.value < 0 {
+			p.add(path+"."+rate.name, "%s cannot be negative", strings.ReplaceAll(rate.name, "_", " "))
+		}
+	}
+}
+
+// validateSourceList checks that every entry under path is a CIDR or an IP address
+func validateSourceList(p *problems, path string, entries []string) {
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		var err error
+		if strings.Contains(entry, "/") {
+			_, err = netip.ParsePrefix(entry)
+		} else {
+			_, err = netip.ParseAddr(entry)
+		}
+		if err != nil {
+			p.add(fmt.Sprintf("%s[%d]", path, i), "invalid CIDR or IP address %q", entry)
+		}
+	}
+}
+
+// validateBind checks the listen address of a tunnel: an IP or localhost with a local port,
+// or an absolute unix:// socket path with valid socket settings
+func validateBind(p *problems, path string, t types.TunnelConfig) {
+	if t.IsUnixSocket() {
+		if socketPath := t.SocketPath(); !filepath.IsAbs(socketPath) {
+			p.add(path+".bind", "unix socket path must be absolute, got %q", socketPath)
+		}
+		if t.LocalPort != 0 {
+			p.add(path+".local_port", "local port is not used by unix socket tunnels")
+		}
+		if t.Socket.Mode != "" {
+			if mode, err := strconv.ParseUint(t.Socket.Mode, 8, 32); err != nil || mode > 0o777 {
+				p.add(path+".socket.mode", "invalid octal file mode %q", t.Socket.Mode)
+			}
+		}
+		return
+	}
+
+	if host := t.BindHost(); host != "localhost" {
+		if _, err := netip.ParseAddr(host); err != nil {
+			p.add(path+".bind", "bind must be an IP address, localhost or unix:///path, got %q", t.Bind)
+		}
+	}
+	if t.Socket != (types.SocketConfig{}) {
+		p.add(path+".socket", "socket settings require a unix:// bind address")
+	}
+	if t.LocalPort <= 0 || t.LocalPort > 65535 {
+		p.add(path+".local_port", "invalid local port %d", t.LocalPort)
+	}
+}
+
+// validateTunnels validates declared tunnels under path
+func validateTunnels(p *problems, path string, tunnels []types.TunnelConfig) {
+	ids := make(map[string]bool)
+	binds := make(map[string]string)
+	for i, t := range tunnels {
+		tunnelPath := fmt.Sprintf("%s[%d]", path, i)
+		if t.ID == "" {
+			p.add(tunnelPath+".id", "id is required")
+		} else if ids[t.ID] {
+			p.add(tunnelPath+".id", "duplicate id %s", t.ID)
+		}
+		ids[t.ID] = true
+
+		validateBind(p, tunnelPath, t)
+		if t.RemoteHost == "" {
+			p.add(tunnelPath+".remote_host", "remote host is required")
+		}
+		if t.RemotePort <= 0 || t.RemotePort > 65535 {
+			p.add(tunnelPath+".remote_port", "invalid remote port %d", t.RemotePort)
+		}
+		if t.Protocol != "" && t.Protocol != types.TunnelProtocolTCP {
+			p.add(tunnelPath+".protocol", "unsupported protocol %q", t.Protocol)
+		}
+		if t.Limits.MaxConnections < 0 {
+			p.add(tunnelPath+".limits.max_connections", "max connections cannot be negative")
+		}
+		validateAdmission(p, tunnelPath+".limits", t.Limits.OnLimit, t.Limits.QueueTimeout, t.Limits.MaxQueue)
+		validateSourceList(p, tunnelPath+".access.allow", t.Access.Allow)
+		validateSourceList(p, tunnelPath+".access.deny", t.Access.Deny)
+		validateBandwidth(p, tunnelPath+".limits.bandwidth", t.Limits.Bandwidth)
+		validateBandwidth(p, tunnelPath+".limits.connection_bandwidth", t.Limits.ConnectionBandwidth)
+		if t.DrainTimeout < 0 {
+			p.add(tunnelPath+".drain_timeout", "drain timeout cannot be negative")
+		}
+		if t.IdleTimeout < 0 {
+			p.add(tunnelPath+".idle_timeout", "idle timeout cannot be negative")
+		}
+		if t.MaxConnectionLifetime < 0 {
+			p.add(tunnelPath+".max_connection_lifetime", "max connection lifetime cannot be negative")
+		}
+
+		if !t.IsEnabled() {
+			continue
+		}
+		bind := t.ListenAddress()
+		if other, exists := binds[bind]; exists {
+			field := ".local_port"
+			if t.IsUnixSocket() {
+				field = ".bind"
+			}
+			p.add(tunnelPath+field, "local address %s is already used by tunnel %s", bind, other)
+		}
+		binds[bind] = t.ID
+	}
+}
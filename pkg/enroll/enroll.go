@@ -0,0 +1,287 @@
+package enroll
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/config"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// maxResponseSize limits the enrollment response body
+const maxResponseSize = 1 << 20
+
+// Subject describes the identity requested in the CSR
+type Subject struct {
+	CommonName string
+	TenantID   string
+	DNSNames   []string
+}
+
+// KeyPair is a generated private key with its issued certificate chain, PEM encoded
+type KeyPair struct {
+	KeyPEM      []byte
+	CertPEM     []byte
+	Certificate *x509.Certificate
+}
+
+// Client submits certificate signing requests to the relay enrollment endpoint
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// enrollRequest is the body sent to the enrollment endpoint
+type enrollRequest struct {
+	CSR string `json:"csr"`
+}
+
+// enrollResponse is the body returned by the enrollment endpoint
+type enrollResponse struct {
+	Certificate string `json:"certificate"`
+	Error       string `json:"error,omitempty"`
+}
+
+// NewClient creates an enrollment client. tlsConfig should trust the relay and,
+// for renewals, present the current client certificate.
+func NewClient(url string, tlsConfig *tls.Config, timeout time.Duration) *Client {
+	return &Client{
+		url: url,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// Enroll generates a key and CSR for subject and returns the issued key pair.
+// bootstrapToken authenticates the first enrollment; renewals pass an empty
+// token and are authenticated by the client certificate.
+func (c *Client) Enroll(ctx context.Context, subject Subject, bootstrapToken string) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	csr, err := createCSR(key, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := c.submit(ctx, csr, bootstrapToken)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("issued certificate does not match the generated key")
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	return &KeyPair{
+		KeyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		CertPEM:     certPEM,
+		Certificate: cert,
+	}, nil
+}
+
+// submit posts the CSR and returns the PEM encoded certificate chain
+func (c *Client) submit(ctx context.Context, csr []byte, bootstrapToken string) ([]byte, error) {
+	body, err := json.Marshal(enrollRequest{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode enrollment request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enrollment request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bootstrapToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bootstrapToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("enrollment request failed: %w", err)
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			_ = cerr // Игнорируем ошибку закрытия тела ответа
+		}
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enrollment response: %w", err)
+	}
+
+	var result enrollResponse
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to decode enrollment response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("enrollment rejected: %s (status %d)", result.Error, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("enrollment rejected with status %d", resp.StatusCode)
+	}
+
+	if result.Certificate == "" {
+		return nil, fmt.Errorf("enrollment response contains no certificate")
+	}
+
+	return []byte(result.Certificate), nil
+}
+
+// createCSR creates a DER encoded certificate signing request for subject
+func createCSR(key crypto.Signer, subject Subject) ([]byte, error) {
+	name := pkix.Name{CommonName: subject.CommonName}
+	if subject.TenantID != "" {
+		// The default mTLS identity mapping reads the tenant from the OU
+		name.OrganizationalUnit = []string{subject.TenantID}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  name,
+		DNSNames: subject.DNSNames,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	return csr, nil
+}
+
+// ParseCertificate returns the first certificate of a PEM encoded chain
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// SubjectFromCertificate returns the subject to request when renewing cert
+func SubjectFromCertificate(cert *x509.Certificate) Subject {
+	subject := Subject{
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		subject.TenantID = cert.Subject.OrganizationalUnit[0]
+	}
+	return subject
+}
+
+// WriteKeyPair stores the key and certificate atomically with private permissions.
+// The key is written first so a file watcher never pairs a new certificate with the old key.
+func WriteKeyPair(certFile, keyFile string, pair *KeyPair) error {
+	if err := writeFile(keyFile, pair.KeyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := writeFile(certFile, pair.CertPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data through a temporary file and rename
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if err := os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+			_ = err // Временный файл уже переименован или удален
+		}
+	}()
+
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+// TLSConfig returns the TLS configuration for the enrollment endpoint from the relay settings.
+// With certFile set the client certificate is read on every handshake so renewals
+// always present the newest pair; otherwise no client certificate is sent.
+func TLSConfig(cfg *types.Config, certFile, keyFile string) (*tls.Config, error) {
+	relayCfg := *cfg
+	relayCfg.Relay.TLS.ClientCert = ""
+	relayCfg.Relay.TLS.ClientKey = ""
+
+	tlsConfig, err := config.CreateTLSConfig(&relayCfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("enrollment requires TLS to be enabled")
+	}
+	// The endpoint speaks HTTP, relay ALPN protocols do not apply
+	tlsConfig.NextProtos = nil
+
+	if certFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+
+	return tlsConfig, nil
+}
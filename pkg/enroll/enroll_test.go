@@ -0,0 +1,114 @@
+package enroll
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newEnrollmentServer returns a server signing CSRs presented with the bootstrap token
+func newEnrollmentServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(enrollResponse{Error: "invalid bootstrap token"})
+			return
+		}
+
+		var req enrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		block, _ := pem.Decode([]byte(req.CSR))
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(3 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caTemplate, csr.PublicKey, caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(enrollResponse{
+			Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		})
+	}))
+}
+
+func TestEnroll(t *testing.T) {
+	server := newEnrollmentServer(t, "bootstrap")
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	client := NewClient(server.URL, &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}, 5*time.Second)
+
+	if _, err := client.Enroll(context.Background(), Subject{CommonName: "branch-1"}, "wrong"); err == nil {
+		t.Error("expected enrollment with an invalid bootstrap token to fail")
+	}
+
+	pair, err := client.Enroll(context.Background(), Subject{CommonName: "branch-1", TenantID: "tenant-a"}, "bootstrap")
+	if err != nil {
+		t.Fatalf("enrollment failed: %v", err)
+	}
+	if got := SubjectFromCertificate(pair.Certificate); got.CommonName != "branch-1" || got.TenantID != "tenant-a" {
+		t.Errorf("unexpected subject %+v", got)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := WriteKeyPair(certFile, keyFile, pair); err != nil {
+		t.Fatalf("failed to store key pair: %v", err)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("stored key pair is invalid: %v", err)
+	}
+
+	renewAt, err := NewRenewer(client, certFile, keyFile, 0).RenewAt()
+	if err != nil {
+		t.Fatalf("failed to compute renewal time: %v", err)
+	}
+	expected := pair.Certificate.NotBefore.Add(pair.Certificate.NotAfter.Sub(pair.Certificate.NotBefore) * 2 / 3)
+	if !renewAt.Equal(expected) {
+		t.Errorf("expected renewal at %v, got %v", expected, renewAt)
+	}
+}
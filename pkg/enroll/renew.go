@@ -0,0 +1,102 @@
+package enroll
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// renewRetryInterval is the delay before retrying a failed renewal
+const renewRetryInterval = 5 * time.Minute
+
+// Renewer renews the client certificate before it expires
+type Renewer struct {
+	client      *Client
+	certFile    string
+	keyFile     string
+	renewBefore time.Duration
+}
+
+// NewRenewer creates a renewer for the key pair in certFile and keyFile.
+// With renewBefore zero the certificate is renewed after two thirds of its lifetime.
+func NewRenewer(client *Client, certFile, keyFile string, renewBefore time.Duration) *Renewer {
+	return &Renewer{
+		client:      client,
+		certFile:    certFile,
+		keyFile:     keyFile,
+		renewBefore: renewBefore,
+	}
+}
+
+// Run renews the certificate on schedule until ctx is canceled.
+// The new pair replaces the files, where the certificate reloader picks it up.
+func (r *Renewer) Run(ctx context.Context) {
+	for {
+		delay := renewRetryInterval
+		if renewAt, err := r.RenewAt(); err != nil {
+			log.Printf("Certificate renewal: %v", err)
+		} else {
+			delay = time.Until(renewAt)
+			log.Printf("Certificate renewal scheduled at %s", renewAt.Format(time.RFC3339))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := r.Renew(ctx); err != nil {
+			log.Printf("Certificate renewal failed: %v, retrying in %v", err, renewRetryInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(renewRetryInterval):
+			}
+		}
+	}
+}
+
+// RenewAt returns when the current certificate should be renewed
+func (r *Renewer) RenewAt() (time.Time, error) {
+	data, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert, err := ParseCertificate(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if r.renewBefore > 0 {
+		return cert.NotAfter.Add(-r.renewBefore), nil
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3), nil
+}
+
+// Renew requests a new certificate for the current subject and stores it
+func (r *Renewer) Renew(ctx context.Context) error {
+	data, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := ParseCertificate(data)
+	if err != nil {
+		return err
+	}
+
+	pair, err := r.client.Enroll(ctx, SubjectFromCertificate(cert), "")
+	if err != nil {
+		return err
+	}
+	if err := WriteKeyPair(r.certFile, r.keyFile, pair); err != nil {
+		return err
+	}
+
+	log.Printf("Client certificate renewed, valid until %s", pair.Certificate.NotAfter.Format(time.RFC3339))
+	return nil
+}
@@ -23,6 +23,15 @@ const (
 	ErrBufferPoolExhausted    = "buffer_pool_exhausted"
 	ErrConnectionTimeout      = "connection_timeout"
 	ErrDataTransferFailed     = "data_transfer_failed"
+	ErrInvalidSignature       = "invalid_signature"
+	ErrUnknownKeyID           = "unknown_key_id"
+	ErrTokenExpired           = "token_expired"
+	ErrTokenNotYetValid       = "token_not_yet_valid"
+	ErrTokenTooOld            = "token_too_old"
+	ErrInvalidIssuer          = "invalid_issuer"
+	ErrInvalidAudience        = "invalid_audience"
+	ErrMissingClaim           = "missing_claim"
+	ErrTunnelNotPermitted     = "tunnel_not_permitted"
 )
 
 // RelayError represents a relay-specific error
@@ -137,33 +137,46 @@ func (m *Manager) sendHeartbeat() error {
 // handleHeartbeatSuccess handles a successful heartbeat
 func (m *Manager) handleHeartbeatSuccess() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.lastBeat = time.Now()
 	m.failCount = 0
+	lastBeat := m.lastBeat
+	m.mu.Unlock()
 
 	// Log success (in production, use proper logging)
-	fmt.Printf("Heartbeat sent successfully at %s\n", m.lastBeat.Format(time.RFC3339))
+	m.logf("Heartbeat sent successfully at %s\n", lastBeat.Format(time.RFC3339))
 }
 
 // handleHeartbeatFailure handles a failed heartbeat
 func (m *Manager) handleHeartbeatFailure(err error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.failCount++
-
-	// Log failure (in production, use proper logging)
-	fmt.Printf("Heartbeat failed (attempt %d/%d): %v\n", m.failCount, m.maxFails, err)
+	failCount, maxFails := m.failCount, m.maxFails
 
 	// Check if we should stop due to too many failures
-	if m.failCount >= m.maxFails {
-		fmt.Printf("Too many heartbeat failures (%d), stopping heartbeat manager\n", m.failCount)
+	stopped := failCount >= maxFails
+	if stopped {
 		m.running = false
 		if m.ticker != nil {
 			m.ticker.Stop()
 		}
 	}
+	m.mu.Unlock()
+
+	// Log failure (in production, use proper logging)
+	m.logf("Heartbeat failed (attempt %d/%d): %v\n", failCount, maxFails, err)
+	if stopped {
+		m.logf("Too many heartbeat failures (%d), stopping heartbeat manager\n", failCount)
+	}
+}
+
+// logf prints a log line labelled with the tenant of the client.
+// Must not be called with m.mu held: the tenant lookup takes the client lock,
+// which Client.Close holds while it stops the manager.
+func (m *Manager) logf(format string, args ...interface{}) {
+	if tenantID := m.client.GetTenantID(); tenantID != "" {
+		format = "[tenant=" + tenantID + "] " + format
+	}
+	fmt.Printf(format, args...)
 }
 
 // SendManualHeartbeat sends a manual heartbeat (for testing)
@@ -0,0 +1,63 @@
+package heartbeat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// lockingClient takes its lock like the relay client: the tenant lookup reads it and
+// Close holds it while stopping the heartbeat manager
+type lockingClient struct {
+	mu sync.RWMutex
+	// sent receives every heartbeat, which then waits for resume
+	sent   chan struct{}
+	resume chan struct{}
+}
+
+func (c *lockingClient) IsConnected() bool        { return true }
+func (c *lockingClient) GetConfig() *types.Config { return &types.Config{} }
+func (c *lockingClient) GetClientID() string      { return "client-1" }
+
+func (c *lockingClient) GetTenantID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return "tenant-1"
+}
+
+func (c *lockingClient) SendHeartbeat() error {
+	select {
+	case c.sent <- struct{}{}:
+	default:
+	}
+	<-c.resume
+	return nil
+}
+
+func TestStopWhileHeartbeating(t *testing.T) {
+	client := &lockingClient{sent: make(chan struct{}, 1), resume: make(chan struct{})}
+	manager := NewManager(client)
+	manager.SetInterval(time.Millisecond)
+	if err := manager.Start(); err != nil {
+		t.Fatalf("failed to start heartbeat: %v", err)
+	}
+
+	// Close takes the client lock as the heartbeat result is handled, then stops the manager
+	<-client.sent
+	closed := make(chan struct{})
+	go func() {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		close(client.resume)
+		time.Sleep(10 * time.Millisecond)
+		manager.Stop()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop deadlocked with a running heartbeat")
+	}
+}
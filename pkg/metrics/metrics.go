@@ -6,24 +6,32 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Metrics represents the metrics system
+// Metrics represents the metrics system.
+// Each instance owns its registry so several clients can share one process.
 type Metrics struct {
-	enabled bool
-	port    int
-	server  *http.Server
+	enabled  bool
+	port     int
+	server   *http.Server
+	registry *prometheus.Registry
 
 	// Prometheus metrics
-	bytesTransferred   *prometheus.CounterVec
-	connectionsHandled *prometheus.CounterVec
-	activeConnections  *prometheus.GaugeVec
-	connectionDuration *prometheus.HistogramVec
-	bufferPoolSize     *prometheus.GaugeVec
-	bufferPoolUsage    *prometheus.GaugeVec
-	errorsTotal        *prometheus.CounterVec
-	heartbeatLatency   *prometheus.HistogramVec
+	bytesTransferred    *prometheus.CounterVec
+	connectionsHandled  *prometheus.CounterVec
+	activeConnections   *prometheus.GaugeVec
+	connectionDuration  *prometheus.HistogramVec
+	bufferPoolSize      *prometheus.GaugeVec
+	bufferPoolUsage     *prometheus.GaugeVec
+	errorsTotal         *prometheus.CounterVec
+	heartbeatLatency    *prometheus.HistogramVec
+	certificateExpiry   *prometheus.GaugeVec
+	throttledSeconds    *prometheus.CounterVec
+	connectionsRejected *prometheus.CounterVec
+	connectionsQueued   *prometheus.CounterVec
+	connectionsClosed   *prometheus.CounterVec
 }
 
 // NewMetrics creates a new metrics system
@@ -60,6 +68,33 @@ func (m *Metrics) initPrometheusMetrics() {
 		[]string{"tunnel_id", "tenant_id"},
 	)
 
+	// Connections refused by admission control
+	m.connectionsRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudbridge_connections_rejected_total",
+			Help: "Total connections rejected by tunnels",
+		},
+		[]string{"tunnel_id", "tenant_id", "reason"},
+	)
+
+	// Connections queued for a free slot
+	m.connectionsQueued = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudbridge_connections_queued_total",
+			Help: "Total connections queued at a connection limit",
+		},
+		[]string{"tunnel_id", "tenant_id"},
+	)
+
+	// Connections by the reason they ended
+	m.connectionsClosed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudbridge_connections_closed_total",
+			Help: "Total tunnel connections closed, by reason",
+		},
+		[]string{"tunnel_id", "tenant_id", "reason"},
+	)
+
 	// Active connections gauge
 	m.activeConnections = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -116,16 +151,42 @@ func (m *Metrics) initPrometheusMetrics() {
 		[]string{"tenant_id"},
 	)
 
+	// Client certificate remaining lifetime gauge
+	m.certificateExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudbridge_client_certificate_remaining_seconds",
+			Help: "Remaining lifetime of the client certificate in seconds",
+		},
+		[]string{"cert_file"},
+	)
+
+	// Bandwidth throttling counter
+	m.throttledSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudbridge_throttled_seconds_total",
+			Help: "Time tunnel transfers waited for bandwidth limits in seconds",
+		},
+		[]string{"tunnel_id", "tenant_id", "direction"},
+	)
+
 	// Register metrics
-	prometheus.MustRegister(
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		m.bytesTransferred,
 		m.connectionsHandled,
+		m.connectionsRejected,
+		m.connectionsQueued,
+		m.connectionsClosed,
 		m.activeConnections,
 		m.connectionDuration,
 		m.bufferPoolSize,
 		m.bufferPoolUsage,
 		m.errorsTotal,
 		m.heartbeatLatency,
+		m.certificateExpiry,
+		m.throttledSeconds,
 	)
 }
 
@@ -136,7 +197,7 @@ func (m *Metrics) Start() error {
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
 
 	m.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", m.port),
@@ -153,6 +214,11 @@ func (m *Metrics) Start() error {
 	return nil
 }
 
+// Registry returns the Prometheus registry, nil when metrics are disabled
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
 // Stop stops the metrics server
 func (m *Metrics) Stop() error {
 	if m.server != nil {
@@ -179,6 +245,33 @@ func (m *Metrics) RecordConnectionHandled(tunnelID, tenantID string) {
 	m.connectionsHandled.WithLabelValues(tunnelID, tenantID).Inc()
 }
 
+// RecordConnectionRejected records a connection refused for reason
+func (m *Metrics) RecordConnectionRejected(tunnelID, tenantID, reason string) {
+	if !m.enabled {
+		return
+	}
+
+	m.connectionsRejected.WithLabelValues(tunnelID, tenantID, reason).Inc()
+}
+
+// RecordConnectionQueued records a connection waiting for a free slot
+func (m *Metrics) RecordConnectionQueued(tunnelID, tenantID string) {
+	if !m.enabled {
+		return
+	}
+
+	m.connectionsQueued.WithLabelValues(tunnelID, tenantID).Inc()
+}
+
+// RecordConnectionClosed records a tunnel connection that ended for reason
+func (m *Metrics) RecordConnectionClosed(tunnelID, tenantID, reason string) {
+	if !m.enabled {
+		return
+	}
+
+	m.connectionsClosed.WithLabelValues(tunnelID, tenantID, reason).Inc()
+}
+
 // SetActiveConnections sets active connections count
 func (m *Metrics) SetActiveConnections(tunnelID, tenantID string, count int) {
 	if !m.enabled {
@@ -197,6 +290,15 @@ func (m *Metrics) RecordConnectionDuration(tunnelID, tenantID string, duration t
 	m.connectionDuration.WithLabelValues(tunnelID, tenantID).Observe(duration.Seconds())
 }
 
+// RecordThrottled records time a transfer waited for a bandwidth limit
+func (m *Metrics) RecordThrottled(tunnelID, tenantID, direction string, waited time.Duration) {
+	if !m.enabled {
+		return
+	}
+
+	m.throttledSeconds.WithLabelValues(tunnelID, tenantID, direction).Add(waited.Seconds())
+}
+
 // SetBufferPoolSize sets buffer pool size
 func (m *Metrics) SetBufferPoolSize(tunnelID string, size int) {
 	if !m.enabled {
@@ -233,6 +335,15 @@ func (m *Metrics) RecordHeartbeatLatency(tenantID string, latency time.Duration)
 	m.heartbeatLatency.WithLabelValues(tenantID).Observe(latency.Seconds())
 }
 
+// SetClientCertificateRemaining sets the remaining lifetime of a client certificate
+func (m *Metrics) SetClientCertificateRemaining(certFile string, remaining time.Duration) {
+	if !m.enabled {
+		return
+	}
+
+	m.certificateExpiry.WithLabelValues(certFile).Set(remaining.Seconds())
+}
+
 // GetMetrics returns current metrics as a map
 func (m *Metrics) GetMetrics() map[string]interface{} {
 	if !m.enabled {
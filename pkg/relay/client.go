@@ -3,10 +3,15 @@ package relay
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
 	"github.com/2gc-dev/cloudbridge-client/pkg/config"
@@ -29,7 +34,10 @@ type Client struct {
 	heartbeatMgr  *heartbeat.Manager
 	retryStrategy *errors.RetryStrategy
 	metrics       *metrics.Metrics
+	ownsMetrics   bool
 	optimizer     *performance.Optimizer
+	certReloader  *config.CertificateReloader
+	clientCert    *tls.Certificate // client certificate presented in the last handshake
 	mu            sync.RWMutex
 	connected     bool
 	clientID      string
@@ -49,23 +57,42 @@ const (
 	MessageTypeHeartbeat         = "heartbeat"
 	MessageTypeHeartbeatResponse = "heartbeat_response"
 	MessageTypeError             = "error"
+	// MessageTypeGoAway is sent by a relay that is shutting down instead of a response
+	MessageTypeGoAway = "goaway"
 )
 
 // NewClient creates a new CloudBridge Relay client
 func NewClient(cfg *types.Config) (*Client, error) {
+	// Create metrics system
+	metrics := metrics.NewMetrics(cfg.Metrics.Enabled, cfg.Metrics.PrometheusPort)
+
+	client, err := newClient(cfg, metrics)
+	if err != nil {
+		return nil, err
+	}
+	client.ownsMetrics = true
+
+	// Start metrics server
+	if err := metrics.Start(); err != nil {
+		client.cancel()
+		return nil, fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
+	return client, nil
+}
+
+// NewClientWithMetrics creates a client that records into metrics owned by the caller,
+// allowing several clients to share one metrics server
+func NewClientWithMetrics(cfg *types.Config, metrics *metrics.Metrics) (*Client, error) {
+	return newClient(cfg, metrics)
+}
+
+// newClient creates a client using the given metrics system
+func newClient(cfg *types.Config, metrics *metrics.Metrics) (*Client, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Create authentication manager
-	authManager, err := auth.NewAuthManager(&auth.AuthConfig{
-		Type:   cfg.Auth.Type,
-		Secret: cfg.Auth.Secret,
-		Keycloak: &auth.KeycloakConfig{
-			ServerURL: cfg.Auth.Keycloak.ServerURL,
-			Realm:     cfg.Auth.Keycloak.Realm,
-			ClientID:  cfg.Auth.Keycloak.ClientID,
-			JWKSURL:   cfg.Auth.Keycloak.JWKSURL,
-		},
-	})
+	authManager, err := auth.NewAuthManager(NewAuthConfig(cfg))
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create auth manager: %w", err)
@@ -78,9 +105,6 @@ func NewClient(cfg *types.Config) (*Client, error) {
 		cfg.RateLimiting.MaxBackoff,
 	)
 
-	// Create metrics system
-	metrics := metrics.NewMetrics(cfg.Metrics.Enabled, cfg.Metrics.PrometheusPort)
-
 	// Create performance optimizer
 	optimizer := performance.NewOptimizer(cfg.Performance.Enabled)
 
@@ -94,8 +118,27 @@ func NewClient(cfg *types.Config) (*Client, error) {
 		cancel:        cancel,
 	}
 
+	// Serve the client certificate through a reloader so renewed pairs are used without restart
+	if cfg.Relay.TLS.Enabled && cfg.Relay.TLS.ClientCert != "" && cfg.Relay.TLS.ClientKey != "" {
+		reloader, err := config.NewCertificateReloader(cfg.Relay.TLS.ClientCert, cfg.Relay.TLS.ClientKey, cfg.Relay.TLS.CertWarnBefore)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		reloader.SetObserver(func(remaining time.Duration) {
+			metrics.SetClientCertificateRemaining(cfg.Relay.TLS.ClientCert, remaining)
+		})
+		if err := reloader.Start(); err != nil {
+			cancel()
+			return nil, err
+		}
+		client.certReloader = reloader
+	}
+
 	// Create tunnel manager
 	client.tunnelManager = tunnel.NewManager(client)
+	client.tunnelManager.SetMetrics(metrics)
+	client.tunnelManager.SetConnectionLimits(cfg.Connections)
 
 	// Create heartbeat manager
 	client.heartbeatMgr = heartbeat.NewManager(client)
@@ -110,13 +153,45 @@ func NewClient(cfg *types.Config) (*Client, error) {
 		}
 	}
 
-	// Start metrics server
-	if err := metrics.Start(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to start metrics server: %w", err)
+	return client, nil
+}
+
+// NewAuthConfig builds the authentication manager configuration from the client configuration
+func NewAuthConfig(cfg *types.Config) *auth.AuthConfig {
+	return &auth.AuthConfig{
+		Type:   cfg.Auth.Type,
+		Secret: cfg.Auth.Secret,
+		Keycloak: &auth.KeycloakConfig{
+			ServerURL: cfg.Auth.Keycloak.ServerURL,
+			Realm:     cfg.Auth.Keycloak.Realm,
+			ClientID:  cfg.Auth.Keycloak.ClientID,
+			JWKSURL:   cfg.Auth.Keycloak.JWKSURL,
+		},
+		OIDC: &auth.OIDCConfig{
+			IssuerURL: cfg.Auth.OIDC.IssuerURL,
+			JWKSURL:   cfg.Auth.OIDC.JWKSURL,
+		},
+		MTLS: &auth.MTLSConfig{
+			CertFile:      cfg.Relay.TLS.ClientCert,
+			SubjectSource: cfg.Auth.MTLS.SubjectSource,
+			TenantSource:  cfg.Auth.MTLS.TenantSource,
+		},
+		Validation: &auth.ValidationPolicy{
+			Issuer:         cfg.Auth.Validation.Issuer,
+			Audience:       cfg.Auth.Validation.Audience,
+			RequiredClaims: cfg.Auth.Validation.RequiredClaims,
+			Leeway:         cfg.Auth.Validation.Leeway,
+			MaxTokenAge:    cfg.Auth.Validation.MaxTokenAge,
+		},
 	}
+}
 
-	return client, nil
+// ApplyToken uses token as the jwt secret when the configuration has none.
+// A configured secret is kept, since tokens are verified against it.
+func ApplyToken(cfg *types.Config, token string) {
+	if token != "" && cfg.Auth.Secret == "" {
+		cfg.Auth.Secret = token
+	}
 }
 
 // Connect establishes a connection to the relay server
@@ -129,20 +204,26 @@ func (c *Client) Connect() error {
 	}
 
 	// Create TLS config
-	tlsConfig, err := config.CreateTLSConfig(c.config)
+	tlsConfig, err := config.CreateTLSConfigWithReloader(c.config, c.certReloader)
 	if err != nil {
 		return fmt.Errorf("failed to create TLS config: %w", err)
 	}
 
 	// Establish connection
 	var conn net.Conn
+	c.clientCert = nil
+	address := net.JoinHostPort(c.config.Relay.Host, strconv.Itoa(c.config.Relay.Port))
 	if tlsConfig != nil {
-		conn, err = tls.Dial("tcp", fmt.Sprintf("%s:%d", c.config.Relay.Host, c.config.Relay.Port), tlsConfig)
+		c.recordClientCertificate(tlsConfig)
+		conn, err = tls.Dial("tcp", address, tlsConfig)
 	} else {
-		conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", c.config.Relay.Host, c.config.Relay.Port))
+		conn, err = net.Dial("tcp", address)
 	}
 
 	if err != nil {
+		if stderrors.Is(err, config.ErrCertificatePinMismatch) {
+			return errors.NewRelayError(errors.ErrTLSHandshakeFailed, fmt.Sprintf("certificate pinning failed: %v", err))
+		}
 		return errors.NewRelayError(errors.ErrTLSHandshakeFailed, fmt.Sprintf("failed to connect: %v", err))
 	}
 
@@ -170,7 +251,41 @@ func (c *Client) Connect() error {
 	return nil
 }
 
-// Authenticate authenticates with the relay server
+// recordClientCertificate makes Connect remember the client certificate tlsConfig presents.
+// The handshake runs inside tls.Dial, so the certificate is recorded before Connect returns.
+func (c *Client) recordClientCertificate(tlsConfig *tls.Config) {
+	if getCertificate := tlsConfig.GetClientCertificate; getCertificate != nil {
+		tlsConfig.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := getCertificate(info)
+			if err == nil {
+				c.clientCert = cert
+			}
+			return cert, err
+		}
+	} else if len(tlsConfig.Certificates) > 0 {
+		c.clientCert = &tlsConfig.Certificates[0]
+	}
+}
+
+// certificateIdentity derives the mtls identity from the client certificate presented during
+// the handshake, which stays valid for the connection even if the file was rotated since
+func (c *Client) certificateIdentity() (*auth.CertificateIdentity, error) {
+	if c.clientCert == nil || len(c.clientCert.Certificate) == 0 {
+		return nil, fmt.Errorf("no client certificate was presented during the TLS handshake")
+	}
+	leaf := c.clientCert.Leaf
+	if leaf == nil {
+		parsed, err := x509.ParseCertificate(c.clientCert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		leaf = parsed
+	}
+	return c.authManager.IdentityFromCertificate(leaf)
+}
+
+// Authenticate authenticates with the relay server.
+// With mtls authentication the token is ignored and the client certificate is used instead.
 func (c *Client) Authenticate(token string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -179,25 +294,43 @@ func (c *Client) Authenticate(token string) error {
 		return fmt.Errorf("not connected")
 	}
 
-	// Validate token and extract claims
-	validatedToken, err := c.authManager.ValidateToken(token)
-	if err != nil {
-		return fmt.Errorf("failed to validate token: %w", err)
-	}
+	var authMsg map[string]interface{}
+	if c.authManager.Type() == "mtls" {
+		// Derive identity from the client certificate presented during the handshake
+		identity, err := c.certificateIdentity()
+		if err != nil {
+			return errors.NewRelayError(errors.ErrAuthenticationFailed, err.Error())
+		}
+		c.tenantID = identity.TenantID
+		authMsg = c.authManager.CreateCertificateAuthMessage(identity)
+	} else {
+		// Validate token and extract claims
+		validatedToken, err := c.authManager.ValidateToken(token)
+		if err != nil {
+			return fmt.Errorf("failed to validate token: %w", err)
+		}
 
-	// Extract subject and tenant_id from token
-	_, tenantID, err := c.authManager.ExtractClaims(validatedToken)
-	if err != nil {
-		return fmt.Errorf("failed to extract claims: %w", err)
-	}
+		// Extract subject and tenant_id from token
+		_, tenantID, err := c.authManager.ExtractClaims(validatedToken)
+		if err != nil {
+			return fmt.Errorf("failed to extract claims: %w", err)
+		}
 
-	// Store tenant ID
-	c.tenantID = tenantID
+		// Store tenant ID
+		c.tenantID = tenantID
 
-	// Create auth message
-	authMsg, err := c.authManager.CreateAuthMessage(token)
-	if err != nil {
-		return fmt.Errorf("failed to create auth message: %w", err)
+		// Restrict tunnels to the token scope
+		scope, err := c.authManager.ExtractTunnelScope(validatedToken)
+		if err != nil {
+			return errors.NewRelayError(errors.ErrInvalidToken, err.Error())
+		}
+		c.tunnelManager.SetScope(scope)
+
+		// Create auth message
+		authMsg, err = c.authManager.CreateAuthMessage(token)
+		if err != nil {
+			return fmt.Errorf("failed to create auth message: %w", err)
+		}
 	}
 
 	// Send auth message
@@ -235,6 +368,16 @@ func (c *Client) Authenticate(token string) error {
 
 // CreateTunnel creates a tunnel with the specified parameters
 func (c *Client) CreateTunnel(tunnelID string, localPort int, remoteHost string, remotePort int) error {
+	return c.CreateTunnelFromConfig(types.TunnelConfig{
+		ID:         tunnelID,
+		LocalPort:  localPort,
+		RemoteHost: remoteHost,
+		RemotePort: remotePort,
+	})
+}
+
+// CreateTunnelFromConfig creates a tunnel from its declarative configuration
+func (c *Client) CreateTunnelFromConfig(tunnel types.TunnelConfig) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -242,14 +385,25 @@ func (c *Client) CreateTunnel(tunnelID string, localPort int, remoteHost string,
 		return fmt.Errorf("not connected")
 	}
 
+	// Reject tunnels outside the token scope before asking the relay
+	if err := c.tunnelManager.AuthorizeTunnel(tunnel.LocalPort, tunnel.RemoteHost, tunnel.RemotePort); err != nil {
+		return err
+	}
+
 	// Create tunnel info message
 	tunnelMsg := map[string]interface{}{
 		"type":        MessageTypeTunnelInfo,
-		"tunnel_id":   tunnelID,
+		"tunnel_id":   tunnel.ID,
 		"tenant_id":   c.tenantID,
-		"local_port":  localPort,
-		"remote_host": remoteHost,
-		"remote_port": remotePort,
+		"local_port":  tunnel.LocalPort,
+		"remote_host": tunnel.RemoteHost,
+		"remote_port": tunnel.RemotePort,
+	}
+	if tunnel.Protocol != "" {
+		tunnelMsg["protocol"] = tunnel.Protocol
+	}
+	if len(tunnel.Labels) > 0 {
+		tunnelMsg["labels"] = tunnel.Labels
 	}
 
 	// Send tunnel message
@@ -278,13 +432,83 @@ func (c *Client) CreateTunnel(tunnelID string, localPort int, remoteHost string,
 	}
 
 	// Register tunnel with tunnel manager
-	if err := c.tunnelManager.RegisterTunnel(tunnelID, localPort, remoteHost, remotePort); err != nil {
+	if err := c.tunnelManager.RegisterTunnelConfig(tunnel); err != nil {
 		return fmt.Errorf("failed to register tunnel: %w", err)
 	}
 
 	return nil
 }
 
+// ApplyTunnels reconciles the running tunnels with desired: new tunnels are created,
+// removed ones drained and changed ones recreated, while updated and unchanged tunnels
+// keep their connections. The new set is checked against the token scope first, so a reload
+// containing a forbidden tunnel changes nothing. A changed tunnel whose new configuration
+// cannot be created is restored with its previous one.
+func (c *Client) ApplyTunnels(desired []types.TunnelConfig) (*tunnel.Diff, error) {
+	diff := tunnel.DiffTunnels(c.tunnelManager.TunnelConfigs(), desired)
+
+	for _, t := range append(append([]types.TunnelConfig{}, diff.Added...), diff.Changed...) {
+		if err := c.tunnelManager.AuthorizeTunnel(t.LocalPort, t.RemoteHost, t.RemotePort); err != nil {
+			return nil, fmt.Errorf("tunnel %s: %w", t.ID, err)
+		}
+	}
+
+	var failed []string
+	for _, id := range diff.Removed {
+		if err := c.tunnelManager.UnregisterTunnel(id); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", id, err))
+		}
+	}
+	for _, t := range diff.Changed {
+		if err := c.replaceTunnel(t); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", t.ID, err))
+		}
+	}
+	for _, t := range diff.Updated {
+		if err := c.tunnelManager.UpdateTunnel(t); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", t.ID, err))
+		}
+	}
+	for _, t := range diff.Added {
+		if err := c.CreateTunnelFromConfig(t); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", t.ID, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return diff, fmt.Errorf("failed to apply tunnels: %s", strings.Join(failed, "; "))
+	}
+	return diff, nil
+}
+
+// replaceTunnel recreates a tunnel with a changed configuration. The old listener has to go
+// first to free its address, so when the new configuration cannot be created the previous
+// one is created again and the tunnel keeps serving.
+func (c *Client) replaceTunnel(cfg types.TunnelConfig) error {
+	current, exists := c.tunnelManager.GetTunnel(cfg.ID)
+	if !exists {
+		return c.CreateTunnelFromConfig(cfg)
+	}
+	previous := current.Config()
+
+	if err := c.tunnelManager.UnregisterTunnel(cfg.ID); err != nil {
+		return err
+	}
+	err := c.CreateTunnelFromConfig(cfg)
+	if err == nil {
+		return nil
+	}
+	if restoreErr := c.CreateTunnelFromConfig(previous); restoreErr != nil {
+		return fmt.Errorf("%w; failed to restore previous configuration: %v", err, restoreErr)
+	}
+	return fmt.Errorf("%w; previous configuration kept", err)
+}
+
+// SetConnectionLimits changes the connection cap of all tunnels and the default behavior at limits
+func (c *Client) SetConnectionLimits(cfg types.ConnectionsConfig) {
+	c.tunnelManager.SetConnectionLimits(cfg)
+}
+
 // StartHeartbeat starts the heartbeat mechanism
 func (c *Client) StartHeartbeat() error {
 	return c.heartbeatMgr.Start()
@@ -295,11 +519,18 @@ func (c *Client) StopHeartbeat() {
 	c.heartbeatMgr.Stop()
 }
 
-// Close closes the client connection and cleans up resources
+// Close drains the tunnels, then closes the client connection and cleans up resources
 func (c *Client) Close() error {
+	// Tunnel connections are given their drain_timeout to finish before the relay session ends
+	c.tunnelManager.DrainAll(0)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.certReloader != nil {
+		c.certReloader.Stop()
+	}
+
 	if !c.connected {
 		return nil
 	}
@@ -307,8 +538,8 @@ func (c *Client) Close() error {
 	// Stop heartbeat
 	c.heartbeatMgr.Stop()
 
-	// Stop metrics server
-	if c.metrics != nil {
+	// Stop metrics server unless it is shared with other clients
+	if c.metrics != nil && c.ownsMetrics {
 		if err := c.metrics.Stop(); err != nil {
 			// Log error but don't fail close operation
 			fmt.Printf("Failed to stop metrics: %v\n", err)
@@ -393,6 +624,7 @@ func (c *Client) SendHeartbeat() error {
 	heartbeatMsg := map[string]interface{}{
 		"type": MessageTypeHeartbeat,
 	}
+	sentAt := time.Now()
 
 	if err := c.sendMessage(heartbeatMsg); err != nil {
 		return fmt.Errorf("failed to send heartbeat: %w", err)
@@ -403,13 +635,34 @@ func (c *Client) SendHeartbeat() error {
 		return fmt.Errorf("failed to receive heartbeat response: %w", err)
 	}
 
+	if response["type"] == MessageTypeGoAway {
+		reason, _ := response["reason"].(string)
+		go c.goAway(reason)
+		return errors.NewRelayError(errors.ErrServerUnavailable, fmt.Sprintf("relay is going away: %s", reason))
+	}
+
 	if response["type"] != MessageTypeHeartbeatResponse {
 		return fmt.Errorf("unexpected response type: %s", response["type"])
 	}
 
+	c.metrics.RecordHeartbeatLatency(c.tenantID, time.Since(sentAt))
 	return nil
 }
 
+// goAway stops the heartbeat and drains the tunnels after the relay announced it is
+// shutting down, then ends the session so Done is closed
+func (c *Client) goAway(reason string) {
+	fmt.Printf("Relay is going away (%s), draining tunnels\n", reason)
+	c.heartbeatMgr.Stop()
+	c.tunnelManager.DrainAll(0)
+	c.cancel()
+}
+
+// Done is closed when the relay session has ended, e.g. after a goaway from the relay
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
 // GetConfig returns the client configuration
 func (c *Client) GetConfig() *types.Config {
 	return c.config
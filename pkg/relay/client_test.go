@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/metrics"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// startFakeRelay answers hello and tunnel_info messages like a relay accepting every tunnel
+// and returns its port
+func startFakeRelay(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start fake relay: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+				for {
+					var msg map[string]interface{}
+					if err := decoder.Decode(&msg); err != nil {
+						return
+					}
+					response := map[string]interface{}{"status": "ok"}
+					switch msg["type"] {
+					case MessageTypeHello:
+						response["type"] = MessageTypeHelloResponse
+					case MessageTypeTunnelInfo:
+						response["type"] = MessageTypeTunnelResponse
+					case MessageTypeHeartbeat:
+						response["type"] = MessageTypeHeartbeatResponse
+					default:
+						response["type"] = MessageTypeError
+					}
+					if err := encoder.Encode(response); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// newConnectedClient creates a client connected to a fake relay
+func newConnectedClient(t *testing.T) *Client {
+	t.Helper()
+	cfg := &types.Config{}
+	cfg.Relay.Host = "127.0.0.1"
+	cfg.Relay.Port = startFakeRelay(t)
+	cfg.Auth.Type = "jwt"
+	cfg.Auth.Secret = "test-secret"
+
+	client, err := newClient(cfg, metrics.NewMetrics(false, 0))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Failed to connect to fake relay: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestApplyTunnelsKeepsTunnelOnFailedChange(t *testing.T) {
+	echoPort := startEchoServer(t)
+	client := newConnectedClient(t)
+
+	original := types.TunnelConfig{ID: "apply-change", LocalPort: 5047, RemoteHost: "127.0.0.1", RemotePort: echoPort}
+	if _, err := client.ApplyTunnels([]types.TunnelConfig{original}); err != nil {
+		t.Fatalf("Failed to apply tunnels: %v", err)
+	}
+
+	// Move the tunnel to a port that is taken
+	taken, err := net.Listen("tcp", "127.0.0.1:5048")
+	if err != nil {
+		t.Fatalf("Failed to occupy port: %v", err)
+	}
+	defer taken.Close()
+	changed := original
+	changed.LocalPort = 5048
+	_, err = client.ApplyTunnels([]types.TunnelConfig{changed})
+	if err == nil || !strings.Contains(err.Error(), "previous configuration kept") {
+		t.Fatalf("Expected the change to fail and keep the tunnel, got %v", err)
+	}
+
+	tun, exists := client.tunnelManager.GetTunnel(original.ID)
+	if !exists || tun.LocalPort != original.LocalPort {
+		t.Fatalf("Expected the previous tunnel to be restored, got %+v", tun)
+	}
+	conn := dialTunnel(t, original.LocalPort)
+	_ = conn.Close()
+}
+
+// newTestCertificate returns a self-signed certificate with the given common name
+func newTestCertificate(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, OrganizationalUnit: []string{"tenant-001"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificateIdentityUsesPresentedCertificate(t *testing.T) {
+	onDisk := newTestCertificate(t, "on-disk")
+	certFile := filepath.Join(t.TempDir(), "client.crt")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: onDisk.Certificate[0]}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+
+	cfg := &types.Config{}
+	cfg.Auth.Type = "mtls"
+	cfg.Relay.TLS.ClientCert = certFile
+	client, err := newClient(cfg, metrics.NewMetrics(false, 0))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.certificateIdentity(); err == nil {
+		t.Fatal("Expected an error before a certificate was presented")
+	}
+
+	// The handshake presents a rotated certificate the file no longer matches
+	presented := newTestCertificate(t, "presented")
+	tlsConfig := &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &presented, nil
+		},
+	}
+	client.recordClientCertificate(tlsConfig)
+	if _, err := tlsConfig.GetClientCertificate(&tls.CertificateRequestInfo{}); err != nil {
+		t.Fatalf("Failed to get client certificate: %v", err)
+	}
+
+	identity, err := client.certificateIdentity()
+	if err != nil {
+		t.Fatalf("Failed to derive identity: %v", err)
+	}
+	if identity.Subject != "presented" || identity.TenantID != "tenant-001" {
+		t.Errorf("Expected identity of the presented certificate, got %+v", identity)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"os"
 )
 
-// Config represents the client configuration
+// Config represents the client configuration.
+//
+// Deprecated: use types.Config. Files in this flat layout are upgraded by `config migrate`.
 type Config struct {
 	UseTLS         bool
 	TLSCertFile    string
@@ -2,9 +2,18 @@ package relay
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
 	"github.com/2gc-dev/cloudbridge-client/pkg/tunnel"
 	"github.com/2gc-dev/cloudbridge-client/pkg/types"
 )
@@ -99,3 +108,700 @@ func TestTunnelConcurrency(t *testing.T) {
 		t.Errorf("Expected 10 tunnels, got %d", len(all))
 	}
 }
+
+func TestTunnelScope(t *testing.T) {
+	mgr := tunnel.NewManager(&mockClient{})
+	mgr.SetScope(&auth.TunnelScope{
+		AllowedTargets: []string{"10.0.0.*:3389", "db.internal:5432-5439"},
+		LocalPorts:     []string{"7000-7010"},
+	})
+
+	allowed := []struct {
+		localPort  int
+		remoteHost string
+		remotePort int
+	}{
+		{7001, "10.0.0.15", 3389},
+		{7002, "DB.internal", 5435},
+	}
+	for _, req := range allowed {
+		if err := mgr.AuthorizeTunnel(req.localPort, req.remoteHost, req.remotePort); err != nil {
+			t.Errorf("expected %s:%d to be allowed, got %v", req.remoteHost, req.remotePort, err)
+		}
+	}
+
+	denied := []struct {
+		localPort  int
+		remoteHost string
+		remotePort int
+	}{
+		{7001, "10.0.1.15", 3389},
+		{7001, "10.0.0.15", 22},
+		{8000, "10.0.0.15", 3389},
+	}
+	for _, req := range denied {
+		err := mgr.AuthorizeTunnel(req.localPort, req.remoteHost, req.remotePort)
+		relayErr, ok := err.(*errors.RelayError)
+		if !ok || relayErr.Code != errors.ErrTunnelNotPermitted {
+			t.Errorf("expected %s:%d on local port %d to be rejected, got %v",
+				req.remoteHost, req.remotePort, req.localPort, err)
+		}
+	}
+
+	if err := mgr.RegisterTunnel("test-tunnel-scope", 7003, "192.168.1.1", 3389); err == nil {
+		t.Error("expected RegisterTunnel to enforce scope, got nil")
+	}
+}
+
+func TestTunnelFromConfig(t *testing.T) {
+	mgr := tunnel.NewManager(&mockClient{})
+	err := mgr.RegisterTunnelConfig(types.TunnelConfig{
+		ID:         "test-tunnel-config",
+		Bind:       "127.0.0.1",
+		LocalPort:  5010,
+		RemoteHost: "test-server",
+		RemotePort: 5432,
+		Limits:     types.TunnelLimitsConfig{MaxConnections: 5},
+		Labels:     map[string]string{"site": "branch-1"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+
+	tun, exists := mgr.GetTunnel("test-tunnel-config")
+	if !exists {
+		t.Fatal("Tunnel not found after creation")
+	}
+	if tun.LocalAddress() != "127.0.0.1:5010" {
+		t.Errorf("Expected local address 127.0.0.1:5010, got %s", tun.LocalAddress())
+	}
+	if tun.Protocol != types.TunnelProtocolTCP {
+		t.Errorf("Expected default protocol tcp, got %s", tun.Protocol)
+	}
+	if tun.Labels["site"] != "branch-1" || tun.Limits.MaxConnections != 5 {
+		t.Errorf("Expected labels and limits to be kept, got %v %+v", tun.Labels, tun.Limits)
+	}
+
+	if err := mgr.RegisterTunnel("test-tunnel-config-2", 5010, "test-server", 5432); err == nil {
+		t.Error("Expected a loopback tunnel to conflict with the bound port")
+	}
+}
+
+func TestTunnelDiff(t *testing.T) {
+	disabled := false
+	current := []types.TunnelConfig{
+		{ID: "keep", LocalPort: 5020, RemoteHost: "a", RemotePort: 22},
+		{ID: "change", LocalPort: 5021, RemoteHost: "b", RemotePort: 22},
+		{ID: "remove", LocalPort: 5022, RemoteHost: "c", RemotePort: 22},
+		{ID: "disable", LocalPort: 5023, RemoteHost: "d", RemotePort: 22},
+	}
+	desired := []types.TunnelConfig{
+		{ID: "keep", LocalPort: 5020, RemoteHost: "a", RemotePort: 22, Protocol: "tcp"},
+		{ID: "change", LocalPort: 5021, RemoteHost: "b", RemotePort: 2222},
+		{ID: "disable", LocalPort: 5023, RemoteHost: "d", RemotePort: 22, Enabled: &disabled},
+		{ID: "add", LocalPort: 5024, RemoteHost: "e", RemotePort: 22},
+	}
+
+	diff := tunnel.DiffTunnels(current, desired)
+	if len(diff.Added) != 1 || diff.Added[0].ID != "add" {
+		t.Errorf("Expected tunnel add to be added, got %v", diff.Added)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].ID != "change" {
+		t.Errorf("Expected tunnel change to be changed, got %v", diff.Changed)
+	}
+	if fmt.Sprint(diff.Removed) != "[disable remove]" {
+		t.Errorf("Expected tunnels disable and remove to be removed, got %v", diff.Removed)
+	}
+	if len(diff.Unchanged) != 1 || diff.Unchanged[0] != "keep" {
+		t.Errorf("Expected tunnel keep to be unchanged, got %v", diff.Unchanged)
+	}
+}
+
+func TestTunnelUnregisterReleasesPort(t *testing.T) {
+	mgr := tunnel.NewManager(&mockClient{})
+	if err := mgr.RegisterTunnel("test-tunnel-release", 5030, "test-server", 22); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	if err := mgr.UnregisterTunnel("test-tunnel-release"); err != nil {
+		t.Fatalf("Failed to remove tunnel: %v", err)
+	}
+	if err := mgr.RegisterTunnel("test-tunnel-release", 5030, "test-server", 2222); err != nil {
+		t.Errorf("Expected port to be reusable after removal, got %v", err)
+	}
+}
+
+// startEchoServer starts a TCP server echoing everything back and returns its port
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start echo server: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// dialTunnel connects to a tunnel and checks that data makes the round trip
+func dialTunnel(t *testing.T, port int) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write to tunnel: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("Expected echo through tunnel, got %q: %v", reply, err)
+	}
+	return conn
+}
+
+func TestTunnelDrain(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+
+	// A connection closed before the deadline completes
+	if err := mgr.RegisterTunnelConfig(types.TunnelConfig{
+		ID: "test-tunnel-drain", Bind: "127.0.0.1", LocalPort: 5040, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+	}); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	conn := dialTunnel(t, 5040)
+
+	drain, err := mgr.StartDrain("test-tunnel-drain", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to start drain: %v", err)
+	}
+	if _, exists := mgr.GetTunnel("test-tunnel-drain"); exists {
+		t.Error("Expected draining tunnel to be removed")
+	}
+	if refused, err := net.DialTimeout("tcp", "127.0.0.1:5040", time.Second); err == nil {
+		_ = refused.Close()
+		t.Error("Expected draining tunnel to refuse new connections")
+	}
+	_ = conn.Close()
+
+	result := drain.Wait()
+	if result.Completed != 1 || result.ForceClosed != 0 {
+		t.Errorf("Expected 1 completed connection, got %+v", result)
+	}
+
+	// A connection still open at the deadline is closed
+	if err := mgr.RegisterTunnelConfig(types.TunnelConfig{
+		ID: "test-tunnel-drain", Bind: "127.0.0.1", LocalPort: 5040, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		DrainTimeout: 100 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Failed to recreate tunnel: %v", err)
+	}
+	conn = dialTunnel(t, 5040)
+	defer conn.Close()
+
+	results := mgr.DrainAll(0)
+	if len(results) != 1 || results[0].ForceClosed != 1 {
+		t.Errorf("Expected 1 force-closed connection, got %+v", results)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected force-closed connection to be closed, got %v", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := tunnel.NewRateLimiter(1000, 500)
+	if wait := limiter.Reserve(500); wait != 0 {
+		t.Errorf("Expected the burst to pass at once, waited %v", wait)
+	}
+	if wait := limiter.Reserve(500); wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("Expected about 500ms wait beyond the burst, got %v", wait)
+	}
+
+	limiter.SetLimit(0, 0)
+	if wait := limiter.Reserve(1 << 20); wait != 0 {
+		t.Errorf("Expected no wait without a limit, got %v", wait)
+	}
+	limiter.SetLimit(2000, 0)
+	if rate, burst := limiter.Limit(); rate != 2000 || burst != 2000 {
+		t.Errorf("Expected burst to default to one second of traffic, got %d/%d", rate, burst)
+	}
+}
+
+func TestTunnelBandwidth(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	cfg := types.TunnelConfig{
+		ID: "test-tunnel-bandwidth", Bind: "127.0.0.1", LocalPort: 5041, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		Limits: types.TunnelLimitsConfig{
+			Bandwidth: types.BandwidthConfig{Upload: 8000, UploadBurst: 4000},
+		},
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+	conn := dialTunnel(t, 5041)
+	defer conn.Close()
+
+	// 4000 bytes beyond the burst take about half a second at 8000 bytes per second
+	payload := make([]byte, 8000)
+	startedAt := time.Now()
+	go func() { _, _ = conn.Write(payload) }()
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read through tunnel: %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected upload to be throttled, took %v", elapsed)
+	}
+
+	// Lifting the limit at runtime applies to the open connection
+	if err := mgr.SetBandwidth(cfg.ID, types.BandwidthConfig{}, types.BandwidthConfig{}); err != nil {
+		t.Fatalf("Failed to change bandwidth: %v", err)
+	}
+	startedAt = time.Now()
+	go func() { _, _ = conn.Write(payload) }()
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read through tunnel: %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected unthrottled upload after lifting the limit, took %v", elapsed)
+	}
+}
+
+func TestTunnelDiffUpdated(t *testing.T) {
+	current := []types.TunnelConfig{{ID: "limited", LocalPort: 5025, RemoteHost: "a", RemotePort: 22}}
+	desired := []types.TunnelConfig{{ID: "limited", LocalPort: 5025, RemoteHost: "a", RemotePort: 22,
+		Limits:       types.TunnelLimitsConfig{Bandwidth: types.BandwidthConfig{Download: 1 << 20}},
+		DrainTimeout: time.Minute,
+	}}
+
+	diff := tunnel.DiffTunnels(current, desired)
+	if len(diff.Updated) != 1 || len(diff.Changed) != 0 {
+		t.Errorf("Expected bandwidth and drain changes to update the tunnel in place, got %+v", diff)
+	}
+}
+
+// expectClosed checks that the tunnel closed conn without serving it
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected connection to be closed")
+	}
+}
+
+func TestTunnelConnectionLimits(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	mgr.SetConnectionLimits(types.ConnectionsConfig{MaxConnections: 2, OnLimit: types.OnLimitReject, QueueTimeout: time.Second})
+	defer mgr.DrainAll(time.Second)
+
+	for _, cfg := range []types.TunnelConfig{
+		{ID: "test-tunnel-reject", LocalPort: 5042, Limits: types.TunnelLimitsConfig{MaxConnections: 1}},
+		{ID: "test-tunnel-queue", LocalPort: 5043, Limits: types.TunnelLimitsConfig{MaxConnections: 1, OnLimit: types.OnLimitQueue}},
+	} {
+		cfg.Bind, cfg.RemoteHost, cfg.RemotePort = "127.0.0.1", "127.0.0.1", echoPort
+		if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+			t.Fatalf("Failed to create tunnel: %v", err)
+		}
+	}
+
+	// The tunnel limit rejects the second connection at once
+	first := dialTunnel(t, 5042)
+	defer first.Close()
+	rejected, err := net.Dial("tcp", "127.0.0.1:5042")
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	expectClosed(t, rejected)
+	_ = rejected.Close()
+	if tun, _ := mgr.GetTunnel("test-tunnel-reject"); tun.Stats.GetStats()["connections_rejected"] != int64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", tun.Stats.GetStats())
+	}
+
+	// A queued connection is served once a slot is free
+	holder := dialTunnel(t, 5043)
+	queued := make(chan net.Conn)
+	go func() {
+		conn, err := net.Dial("tcp", "127.0.0.1:5043")
+		if err == nil {
+			_, err = conn.Write([]byte("ping"))
+		}
+		if err == nil {
+			_, err = io.ReadFull(conn, make([]byte, 4))
+		}
+		if err != nil {
+			t.Errorf("Expected queued connection to be served: %v", err)
+		}
+		queued <- conn
+	}()
+	time.Sleep(200 * time.Millisecond)
+	_ = holder.Close()
+	conn := <-queued
+	if tun, _ := mgr.GetTunnel("test-tunnel-queue"); tun.Stats.GetStats()["connections_queued"] != int64(1) {
+		t.Errorf("Expected 1 queued connection, got %v", tun.Stats.GetStats())
+	}
+
+	// Both tunnels now use the two client slots
+	mgr.SetConnectionLimits(types.ConnectionsConfig{MaxConnections: 2, OnLimit: types.OnLimitReject})
+	if err := mgr.UpdateTunnel(types.TunnelConfig{ID: "test-tunnel-queue", Limits: types.TunnelLimitsConfig{MaxConnections: 5}}); err != nil {
+		t.Fatalf("Failed to update tunnel: %v", err)
+	}
+	overCap, err := net.Dial("tcp", "127.0.0.1:5043")
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	expectClosed(t, overCap)
+	_ = overCap.Close()
+	_ = conn.Close()
+}
+
+func TestAccessList(t *testing.T) {
+	access, err := tunnel.NewAccessList(types.AccessConfig{
+		Allow: []string{"10.0.0.0/8", "::1"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse access list: %v", err)
+	}
+	for addr, want := range map[string]bool{
+		"10.2.3.4":        true,
+		"::ffff:10.2.3.4": true,
+		"::1":             true,
+		"10.1.2.3":        false,
+		"192.168.1.1":     false,
+	} {
+		if got := access.Allows(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Allows(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if _, err := tunnel.NewAccessList(types.AccessConfig{Deny: []string{"not-an-ip"}}); err == nil {
+		t.Error("Expected invalid entry to be rejected")
+	}
+}
+
+func TestTunnelAccess(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	cfg := types.TunnelConfig{
+		ID: "test-tunnel-access", Bind: "127.0.0.1", LocalPort: 5044, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		Access: types.AccessConfig{Deny: []string{"127.0.0.0/8"}},
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+
+	denied, err := net.Dial("tcp", "127.0.0.1:5044")
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	expectClosed(t, denied)
+	_ = denied.Close()
+	if tun, _ := mgr.GetTunnel(cfg.ID); tun.Stats.GetStats()["connections_rejected"] != int64(1) {
+		t.Errorf("Expected denied connection to be counted, got %v", tun.Stats.GetStats())
+	}
+
+	// Reloaded lists apply to new connections
+	cfg.Access = types.AccessConfig{Allow: []string{"127.0.0.1"}}
+	if err := mgr.UpdateTunnel(cfg); err != nil {
+		t.Fatalf("Failed to update tunnel: %v", err)
+	}
+	conn := dialTunnel(t, 5044)
+	_ = conn.Close()
+}
+
+func TestTunnelBind(t *testing.T) {
+	mgr := tunnel.NewManager(&mockClient{})
+	if err := mgr.RegisterTunnel("test-tunnel-loopback", 5045, "test-server", 5432); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+
+	tun, _ := mgr.GetTunnel("test-tunnel-loopback")
+	if tun.LocalAddress() != "127.0.0.1:5045" {
+		t.Errorf("Expected tunnels to listen on loopback by default, got %s", tun.LocalAddress())
+	}
+	// All interfaces include loopback
+	if err := mgr.RegisterTunnelConfig(types.TunnelConfig{
+		ID: "test-tunnel-all", Bind: "0.0.0.0", LocalPort: 5045, RemoteHost: "test-server", RemotePort: 5432,
+	}); err == nil {
+		t.Error("Expected a tunnel on all interfaces to conflict with the loopback tunnel")
+	}
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "::1", false},
+		{"0.0.0.0", "10.0.0.1", true},
+		{"::1", "::", true},
+		{"", "127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		if got := types.BindsOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("BindsOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelUnixSocket(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	path := filepath.Join(t.TempDir(), "tunnel.sock")
+	cfg := types.TunnelConfig{
+		ID: "test-tunnel-unix", Bind: "unix://" + path, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		Socket: types.SocketConfig{Mode: "0660"},
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected socket file: %v", err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("Expected socket mode 0660, got %v", info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write to tunnel: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("Expected echo through tunnel, got %q: %v", reply, err)
+	}
+	_ = conn.Close()
+
+	// Half-closes pass through the socket in both directions: the server answers
+	// and finishes first, then still receives the request the client half-closes
+	received := make(chan string, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("ready"))
+		_ = conn.(*net.TCPConn).CloseWrite()
+		request, _ := io.ReadAll(conn)
+		received <- string(request)
+	}()
+	halfCfg := types.TunnelConfig{
+		ID: "test-tunnel-unix-half", Bind: "unix://" + filepath.Join(t.TempDir(), "half.sock"),
+		RemoteHost: "127.0.0.1", RemotePort: ln.Addr().(*net.TCPAddr).Port,
+	}
+	if err := mgr.RegisterTunnelConfig(halfCfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+	conn, err = net.Dial("unix", strings.TrimPrefix(halfCfg.Bind, "unix://"))
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if greeting, err := io.ReadAll(conn); err != nil || string(greeting) != "ready" {
+		t.Fatalf("Expected greeting and end of stream, got %q: %v", greeting, err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Expected the socket to stay writable after the server finished: %v", err)
+	}
+	_ = conn.(*net.UnixConn).CloseWrite()
+	select {
+	case request := <-received:
+		if request != "ping" {
+			t.Errorf("Expected the server to receive ping, got %q", request)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the client half-close to reach the server")
+	}
+
+	cfg.ID = "test-tunnel-unix-2"
+	if err := mgr.RegisterTunnelConfig(cfg); err == nil {
+		t.Error("Expected a second tunnel on the same socket to fail")
+	}
+
+	if _, err := mgr.DrainTunnel("test-tunnel-unix", time.Second); err != nil {
+		t.Fatalf("Failed to drain tunnel: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed, got %v", err)
+	}
+
+	// Files other than sockets are never replaced
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err == nil {
+		t.Error("Expected a regular file at the socket path to be kept")
+	}
+}
+
+func TestTunnelTimeouts(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	cfg := types.TunnelConfig{
+		ID: "test-tunnel-timeouts", LocalPort: 5046, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		IdleTimeout: 200 * time.Millisecond,
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+	tun, _ := mgr.GetTunnel(cfg.ID)
+
+	closedBy := func(reason string) int64 {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			closed := tun.Stats.GetStats()["connections_closed"].(map[string]int64)
+			if closed[reason] > 0 || time.Now().After(deadline) {
+				return closed[reason]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// An idle connection is closed on both sides
+	conn := dialTunnel(t, 5046)
+	expectClosed(t, conn)
+	_ = conn.Close()
+	if closedBy(tunnel.CloseReasonIdleTimeout) != 1 {
+		t.Errorf("Expected an idle_timeout close, got %v", tun.Stats.GetStats())
+	}
+
+	// Timeouts are updated in place and a busy connection still reaches its lifetime
+	updated := cfg
+	updated.IdleTimeout = 0
+	updated.MaxConnectionLifetime = 300 * time.Millisecond
+	if diff := tunnel.DiffTunnels([]types.TunnelConfig{cfg}, []types.TunnelConfig{updated}); len(diff.Updated) != 1 {
+		t.Fatalf("Expected timeouts to be updated in place, got %+v", diff)
+	}
+	if err := mgr.UpdateTunnel(updated); err != nil {
+		t.Fatalf("Failed to update tunnel: %v", err)
+	}
+	conn = dialTunnel(t, 5046)
+	defer conn.Close()
+	startedAt := time.Now()
+	reply := make([]byte, 4)
+	for time.Since(startedAt) < 2*time.Second {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			break
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if elapsed := time.Since(startedAt); elapsed >= 2*time.Second {
+		t.Fatalf("Expected the connection to be closed at its lifetime, still open after %v", elapsed)
+	}
+	if closedBy(tunnel.CloseReasonMaxLifetime) != 1 {
+		t.Errorf("Expected a max_connection_lifetime close, got %v", tun.Stats.GetStats())
+	}
+}
+
+func TestTunnelIdleTimeoutWhileThrottled(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	cfg := types.TunnelConfig{
+		ID: "test-tunnel-idle-throttled", LocalPort: 5050, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		IdleTimeout: 200 * time.Millisecond,
+		Limits: types.TunnelLimitsConfig{
+			Bandwidth: types.BandwidthConfig{Upload: 4000, UploadBurst: 1000},
+		},
+	}
+	if err := mgr.RegisterTunnelConfig(cfg); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+	defer mgr.DrainAll(time.Second)
+	tun, _ := mgr.GetTunnel(cfg.ID)
+	conn := dialTunnel(t, 5050)
+	defer conn.Close()
+
+	// 3000 bytes beyond the burst wait about 750ms for the limit, well past the idle timeout
+	payload := make([]byte, 4000)
+	startedAt := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("Failed to write through tunnel: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Expected throttled transfer to complete, got %v after %v (%v)", err, time.Since(startedAt), tun.Stats.GetStats())
+	}
+	if elapsed := time.Since(startedAt); elapsed < 500*time.Millisecond {
+		t.Errorf("Expected upload to be throttled, took %v", elapsed)
+	}
+	closed := tun.Stats.GetStats()["connections_closed"].(map[string]int64)
+	if closed[tunnel.CloseReasonIdleTimeout] != 0 {
+		t.Errorf("Expected no idle_timeout close during a throttled transfer, got %v", closed)
+	}
+
+	// Once the transfer is done the connection is idle again
+	expectClosed(t, conn)
+}
+
+func TestTunnelQueueLimit(t *testing.T) {
+	echoPort := startEchoServer(t)
+	mgr := tunnel.NewManager(&mockClient{})
+	defer mgr.DrainAll(time.Second)
+	if err := mgr.RegisterTunnelConfig(types.TunnelConfig{
+		ID: "test-tunnel-queue-limit", LocalPort: 5049, RemoteHost: "127.0.0.1", RemotePort: echoPort,
+		Limits: types.TunnelLimitsConfig{MaxConnections: 1, OnLimit: types.OnLimitQueue, QueueTimeout: 5 * time.Second, MaxQueue: 1},
+	}); err != nil {
+		t.Fatalf("Failed to create tunnel: %v", err)
+	}
+
+	holder := dialTunnel(t, 5049)
+	defer holder.Close()
+	waiting, err := net.Dial("tcp", "127.0.0.1:5049")
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	defer waiting.Close()
+	tun, _ := mgr.GetTunnel("test-tunnel-queue-limit")
+	deadline := time.Now().Add(2 * time.Second)
+	for tun.Stats.GetStats()["connections_queued"] != int64(1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The queue is full, so the next connection is rejected at once
+	overflow, err := net.Dial("tcp", "127.0.0.1:5049")
+	if err != nil {
+		t.Fatalf("Failed to connect to tunnel: %v", err)
+	}
+	startedAt := time.Now()
+	expectClosed(t, overflow)
+	_ = overflow.Close()
+	if waited := time.Since(startedAt); waited > time.Second {
+		t.Errorf("Expected overflow connection to be rejected at once, waited %v", waited)
+	}
+	stats := tun.Stats.GetStats()
+	if stats["connections_queued"] != int64(1) || stats["connections_rejected"] != int64(1) {
+		t.Errorf("Expected 1 queued and 1 rejected connection, got %v", stats)
+	}
+}
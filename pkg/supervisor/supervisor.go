@@ -0,0 +1,462 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/2gc-dev/cloudbridge-client/pkg/metrics"
+	"github.com/2gc-dev/cloudbridge-client/pkg/relay"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// Identity is a named client run by the supervisor
+type Identity struct {
+	Name    string
+	config  *types.Config
+	tunnels []types.TunnelConfig
+	token   string
+	client  *relay.Client
+	logger  *log.Logger
+	err     error
+	// tunnelErrs holds the tunnels that failed to start
+	tunnelErrs map[string]error
+	mu         sync.RWMutex
+}
+
+// Client returns the relay client of the identity, which is replaced when the identity reconnects
+func (id *Identity) Client() *relay.Client {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.client
+}
+
+// Err returns the error that stopped the identity from starting, if any
+func (id *Identity) Err() error {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.err
+}
+
+// TunnelErrors returns the errors of tunnels that failed to start, keyed by tunnel ID
+func (id *Identity) TunnelErrors() map[string]error {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+
+	errs := make(map[string]error, len(id.tunnelErrs))
+	for tunnelID, err := range id.tunnelErrs {
+		errs[tunnelID] = err
+	}
+	return errs
+}
+
+// setTunnelErr records a tunnel startup error
+func (id *Identity) setTunnelErr(tunnelID string, err error) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.tunnelErrs == nil {
+		id.tunnelErrs = make(map[string]error)
+	}
+	id.tunnelErrs[tunnelID] = err
+}
+
+// setErr records a startup error
+func (id *Identity) setErr(err error) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.err = err
+}
+
+// Supervisor runs several identities in one process with shared metrics
+type Supervisor struct {
+	metrics    *metrics.Metrics
+	identities []*Identity
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewSupervisor creates a supervisor for the identities in cfg
+func NewSupervisor(cfg *types.Config) (*Supervisor, error) {
+	if len(cfg.Identities) == 0 {
+		return nil, fmt.Errorf("no identities configured")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Supervisor{
+		metrics: metrics.NewMetrics(cfg.Metrics.Enabled, cfg.Metrics.PrometheusPort),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for _, identityCfg := range cfg.Identities {
+		identity, err := s.newIdentity(cfg, identityCfg)
+		if err != nil {
+			s.Stop()
+			return nil, fmt.Errorf("identity %s: %w", identityCfg.Name, err)
+		}
+		s.identities = append(s.identities, identity)
+	}
+
+	return s, nil
+}
+
+// newIdentity resolves the configuration and token of an identity and creates its client
+func (s *Supervisor) newIdentity(base *types.Config, identityCfg types.IdentityConfig) (*Identity, error) {
+	cfg := MergeIdentityConfig(base, identityCfg)
+
+	token, err := resolveToken(cfg, identityCfg)
+	if err != nil {
+		return nil, err
+	}
+	relay.ApplyToken(cfg, token)
+
+	client, err := relay.NewClientWithMetrics(cfg, s.metrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return &Identity{
+		Name:    identityCfg.Name,
+		config:  cfg,
+		tunnels: identityCfg.Tunnels,
+		token:   token,
+		client:  client,
+		logger:  log.New(os.Stderr, fmt.Sprintf("[identity=%s] ", identityCfg.Name), log.LstdFlags),
+	}, nil
+}
+
+// Start starts the metrics server and every identity concurrently.
+// Identities that fail are reported through Identity.Err; an error is
+// returned only when no identity could be started.
+func (s *Supervisor) Start() error {
+	if err := s.metrics.Start(); err != nil {
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, identity := range s.identities {
+		wg.Add(1)
+		go func(identity *Identity) {
+			defer wg.Done()
+			if err := s.startIdentity(identity); err != nil {
+				identity.setErr(err)
+				identity.logger.Printf("Failed to start: %v", err)
+				return
+			}
+			go s.watch(identity)
+		}(identity)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, identity := range s.identities {
+		if identity.Err() != nil {
+			failed = append(failed, identity.Name)
+		}
+	}
+	if len(failed) == len(s.identities) {
+		return fmt.Errorf("all identities failed to start: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// startIdentity connects, authenticates and creates the tunnels of one identity
+func (s *Supervisor) startIdentity(identity *Identity) error {
+	client := identity.Client()
+
+	if err := s.withRetry(identity, "Connection", client.Connect); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	identity.logger.Printf("Connected to relay server %s:%d", identity.config.Relay.Host, identity.config.Relay.Port)
+
+	if err := s.withRetry(identity, "Authentication", func() error {
+		return client.Authenticate(identity.token)
+	}); err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	// Label further log lines with the tenant
+	identity.logger.SetPrefix(fmt.Sprintf("[identity=%s tenant=%s] ", identity.Name, client.GetTenantID()))
+	identity.logger.Printf("Authenticated with client ID: %s", client.GetClientID())
+
+	// A failing tunnel is reported without stopping the others
+	var created, failed int
+	for _, t := range identity.tunnels {
+		t := t
+		if !t.IsEnabled() {
+			identity.logger.Printf("Tunnel %s is disabled, skipping", t.ID)
+			continue
+		}
+		if err := s.withRetry(identity, "Tunnel creation", func() error {
+			return client.CreateTunnelFromConfig(t)
+		}); err != nil {
+			identity.setTunnelErr(t.ID, err)
+			identity.logger.Printf("Failed to create tunnel %s: %v", t.ID, err)
+			failed++
+			continue
+		}
+		created++
+		identity.logger.Printf("Created tunnel %s: %s -> %s:%d",
+			t.ID, t.ListenAddress(), t.RemoteHost, t.RemotePort)
+	}
+	if failed > 0 && created == 0 {
+		return fmt.Errorf("failed to create all %d tunnels", failed)
+	}
+
+	if err := client.StartHeartbeat(); err != nil {
+		return fmt.Errorf("failed to start heartbeat: %w", err)
+	}
+
+	return nil
+}
+
+// watch reconnects an identity whose relay session ended, e.g. after a goaway,
+// until the supervisor is stopped or the identity fails to start again
+func (s *Supervisor) watch(identity *Identity) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-identity.Client().Done():
+		}
+		// Closing the clients on Stop ends their sessions as well
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		identity.logger.Printf("Relay session ended, reconnecting")
+		if err := s.restartIdentity(identity); err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			identity.setErr(err)
+			identity.logger.Printf("Failed to reconnect: %v", err)
+			return
+		}
+		identity.logger.Printf("Reconnected to relay server %s:%d", identity.config.Relay.Host, identity.config.Relay.Port)
+	}
+}
+
+// restartIdentity replaces the ended client of an identity with a new one and starts it
+func (s *Supervisor) restartIdentity(identity *Identity) error {
+	if err := identity.Client().Close(); err != nil {
+		identity.logger.Printf("Failed to close client: %v", err)
+	}
+
+	client, err := relay.NewClientWithMetrics(identity.config, s.metrics)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	identity.mu.Lock()
+	identity.client = client
+	identity.tunnelErrs = nil
+	identity.mu.Unlock()
+
+	// Stop may have closed the previous client while this one was created
+	if s.ctx.Err() != nil {
+		if err := client.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия, супервизор уже остановлен
+		}
+		return s.ctx.Err()
+	}
+	return s.startIdentity(identity)
+}
+
+// withRetry runs op with the client's retry strategy until it succeeds or is not retryable
+func (s *Supervisor) withRetry(identity *Identity, action string, op func() error) error {
+	retryStrategy := identity.Client().GetRetryStrategy()
+
+	for {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		relayErr, _ := errors.HandleError(err)
+		if relayErr == nil || !retryStrategy.ShouldRetry(err) {
+			return err
+		}
+
+		delay := retryStrategy.GetNextDelay(err)
+		identity.logger.Printf("%s failed: %v, retrying in %v...", action, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+}
+
+// Reload applies the tunnel changes in cfg to the running identities.
+// Identities are matched by name; added or removed identities require a restart.
+func (s *Supervisor) Reload(cfg *types.Config) error {
+	var failed []string
+	seen := make(map[string]bool)
+	for _, identityCfg := range cfg.Identities {
+		seen[identityCfg.Name] = true
+
+		identity, exists := s.Identity(identityCfg.Name)
+		if !exists {
+			log.Printf("Identity %s was added, restart to start it", identityCfg.Name)
+			continue
+		}
+		if identity.Err() != nil {
+			continue
+		}
+
+		client := identity.Client()
+		diff, err := client.ApplyTunnels(identityCfg.Tunnels)
+		if diff != nil {
+			client.SetConnectionLimits(cfg.Connections)
+			identity.logger.Printf("Tunnels reloaded: %d added, %d changed, %d updated, %d removed",
+				len(diff.Added), len(diff.Changed), len(diff.Updated), len(diff.Removed))
+		}
+		if err != nil {
+			identity.logger.Printf("Tunnel reload failed: %v", err)
+			failed = append(failed, identity.Name)
+			continue
+		}
+		identity.tunnels = identityCfg.Tunnels
+	}
+
+	for _, identity := range s.identities {
+		if !seen[identity.Name] {
+			log.Printf("Identity %s was removed, restart to stop it", identity.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("tunnel reload failed for identities: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Identities returns the supervised identities
+func (s *Supervisor) Identities() []*Identity {
+	return s.identities
+}
+
+// Identity returns an identity by name
+func (s *Supervisor) Identity(name string) (*Identity, bool) {
+	for _, identity := range s.identities {
+		if identity.Name == name {
+			return identity, true
+		}
+	}
+	return nil, false
+}
+
+// Stop closes every identity and the shared metrics server.
+// Identities drain their tunnels concurrently.
+func (s *Supervisor) Stop() {
+	s.cancel()
+
+	var wg sync.WaitGroup
+	for _, identity := range s.identities {
+		wg.Add(1)
+		go func(identity *Identity) {
+			defer wg.Done()
+			if err := identity.Client().Close(); err != nil {
+				identity.logger.Printf("Failed to close client: %v", err)
+			}
+		}(identity)
+	}
+	wg.Wait()
+
+	if err := s.metrics.Stop(); err != nil {
+		log.Printf("Failed to stop metrics: %v", err)
+	}
+}
+
+// MergeIdentityConfig returns a copy of base with the identity overrides applied
+func MergeIdentityConfig(base *types.Config, identity types.IdentityConfig) *types.Config {
+	cfg := *base
+	cfg.Identities = nil
+
+	relayCfg := identity.Relay
+	if relayCfg.Host != "" {
+		cfg.Relay.Host = relayCfg.Host
+	}
+	if relayCfg.Port != 0 {
+		cfg.Relay.Port = relayCfg.Port
+	}
+	if relayCfg.Timeout != 0 {
+		cfg.Relay.Timeout = relayCfg.Timeout
+	}
+	if relayCfg.TLS.ServerName != "" {
+		cfg.Relay.TLS.ServerName = relayCfg.TLS.ServerName
+	}
+	if relayCfg.TLS.CACert != "" {
+		cfg.Relay.TLS.CACert = relayCfg.TLS.CACert
+	}
+	if relayCfg.TLS.ClientCert != "" {
+		cfg.Relay.TLS.ClientCert = relayCfg.TLS.ClientCert
+		cfg.Relay.TLS.ClientKey = relayCfg.TLS.ClientKey
+	}
+
+	authCfg := identity.Auth
+	if authCfg.Type != "" {
+		cfg.Auth.Type = authCfg.Type
+	}
+	if authCfg.Secret != "" {
+		cfg.Auth.Secret = authCfg.Secret
+	}
+	if authCfg.CredentialStore.Identity != "" {
+		cfg.Auth.CredentialStore.Identity = authCfg.CredentialStore.Identity
+	} else {
+		cfg.Auth.CredentialStore.Identity = identity.Name
+	}
+
+	return &cfg
+}
+
+// resolveToken returns the identity token from the config, token file or credential store
+func resolveToken(cfg *types.Config, identity types.IdentityConfig) (string, error) {
+	switch {
+	case identity.Token != "":
+		return identity.Token, nil
+	case identity.TokenFile != "":
+		data, err := os.ReadFile(identity.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case cfg.Auth.Type == "mtls":
+		return "", nil
+	case cfg.Auth.CredentialStore.Enabled:
+		path := cfg.Auth.CredentialStore.Path
+		if path == "" {
+			defaultPath, err := auth.DefaultCredentialStorePath()
+			if err != nil {
+				return "", err
+			}
+			path = defaultPath
+		}
+		secret, err := auth.LoadStoreSecret(cfg.Auth.CredentialStore.KeyFile)
+		if err != nil {
+			return "", err
+		}
+		store, err := auth.OpenCredentialStore(path, secret)
+		if err != nil {
+			return "", err
+		}
+		cred, err := store.Load(cfg.Auth.CredentialStore.Identity)
+		if err != nil {
+			return "", err
+		}
+		if cred.Expired() {
+			return "", fmt.Errorf("cached token has expired")
+		}
+		return cred.AccessToken, nil
+	default:
+		return "", fmt.Errorf("no token, token_file or credential store configured")
+	}
+}
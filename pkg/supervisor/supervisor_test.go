@@ -0,0 +1,265 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testConfig() *types.Config {
+	return &types.Config{
+		Relay: types.RelayConfig{
+			Host: "edge.example.com",
+			Port: 8080,
+		},
+		Auth: types.AuthConfig{
+			Type:   "jwt",
+			Secret: "shared-secret",
+		},
+		Metrics: types.MetricsConfig{
+			Enabled: true,
+		},
+		Identities: []types.IdentityConfig{
+			{Name: "tenant-a", Token: "token-a"},
+			{
+				Name:  "tenant-b",
+				Token: "token-b",
+				Relay: types.RelayConfig{Host: "edge-b.example.com"},
+				Auth:  types.AuthConfig{Secret: "secret-b"},
+			},
+		},
+	}
+}
+
+func TestMergeIdentityConfig(t *testing.T) {
+	base := testConfig()
+
+	a := MergeIdentityConfig(base, base.Identities[0])
+	if a.Relay.Host != "edge.example.com" || a.Auth.Secret != "shared-secret" {
+		t.Errorf("expected identity a to inherit base settings, got %s/%s", a.Relay.Host, a.Auth.Secret)
+	}
+	if a.Auth.CredentialStore.Identity != "tenant-a" {
+		t.Errorf("expected credential store identity tenant-a, got %s", a.Auth.CredentialStore.Identity)
+	}
+
+	b := MergeIdentityConfig(base, base.Identities[1])
+	if b.Relay.Host != "edge-b.example.com" || b.Relay.Port != 8080 {
+		t.Errorf("expected relay override edge-b.example.com:8080, got %s:%d", b.Relay.Host, b.Relay.Port)
+	}
+	if b.Auth.Secret != "secret-b" || b.Auth.Type != "jwt" {
+		t.Errorf("expected auth override secret-b/jwt, got %s/%s", b.Auth.Secret, b.Auth.Type)
+	}
+	if len(b.Identities) != 0 {
+		t.Error("expected merged config to drop nested identities")
+	}
+	if base.Relay.Host != "edge.example.com" {
+		t.Error("merge modified the base configuration")
+	}
+}
+
+func TestNewSupervisorSharesMetrics(t *testing.T) {
+	// Creating supervisors twice must not collide on global metric registration
+	for i := 0; i < 2; i++ {
+		sup, err := NewSupervisor(testConfig())
+		if err != nil {
+			t.Fatalf("failed to create supervisor: %v", err)
+		}
+
+		if len(sup.Identities()) != 2 {
+			t.Fatalf("expected 2 identities, got %d", len(sup.Identities()))
+		}
+		a, _ := sup.Identity("tenant-a")
+		b, _ := sup.Identity("tenant-b")
+		if a.Client().GetMetrics() != b.Client().GetMetrics() {
+			t.Error("expected identities to share the metrics system")
+		}
+
+		sup.Stop()
+	}
+}
+
+// startFakeRelay accepts hello, auth and heartbeat messages and sends every received token
+// to tokens. The first heartbeat of a session authenticated with goAwayToken gets a goaway.
+func startFakeRelay(t *testing.T, tokens chan<- string, goAwayToken string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake relay: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	var goAwaySent atomic.Bool
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+				var sessionToken string
+				for {
+					var msg map[string]interface{}
+					if err := decoder.Decode(&msg); err != nil {
+						return
+					}
+					response := map[string]interface{}{"status": "ok"}
+					switch msg["type"] {
+					case "hello":
+						response["type"] = "hello_response"
+					case "auth":
+						sessionToken, _ = msg["token"].(string)
+						tokens <- sessionToken
+						response["type"] = "auth_response"
+						response["client_id"] = "client-1"
+					case "heartbeat":
+						response["type"] = "heartbeat_response"
+						if goAwayToken != "" && sessionToken == goAwayToken && goAwaySent.CompareAndSwap(false, true) {
+							response = map[string]interface{}{"type": "goaway", "reason": "maintenance"}
+						}
+					default:
+						return
+					}
+					if err := encoder.Encode(response); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestIdentityAuthenticatesWithTokenFile(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       "office-7",
+		"tenant_id": "tenant-file",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("shared-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+
+	tokens := make(chan string, 1)
+	cfg := testConfig()
+	cfg.Relay.Host = "127.0.0.1"
+	cfg.Relay.Port = startFakeRelay(t, tokens, "")
+	cfg.Metrics.Enabled = false
+	cfg.Identities = []types.IdentityConfig{{Name: "from-file", TokenFile: tokenFile}}
+
+	sup, err := NewSupervisor(cfg)
+	if err != nil {
+		t.Fatalf("failed to create supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	identity, _ := sup.Identity("from-file")
+	if identity.config.Auth.Secret != "shared-secret" {
+		t.Errorf("expected the configured secret to be kept, got %q", identity.config.Auth.Secret)
+	}
+	client := identity.Client()
+	if err := client.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := client.Authenticate(identity.token); err != nil {
+		t.Fatalf("failed to authenticate with the token file: %v", err)
+	}
+	if received := <-tokens; received != token {
+		t.Errorf("expected the relay to receive the token file, got %q", received)
+	}
+	if tenant := client.GetTenantID(); tenant != "tenant-file" {
+		t.Errorf("expected tenant-file, got %q", tenant)
+	}
+}
+
+func TestIdentityTokenWithoutSecret(t *testing.T) {
+	// Like --token in single mode, the token is the secret when none is configured
+	cfg := testConfig()
+	cfg.Auth.Secret = ""
+	cfg.Identities = []types.IdentityConfig{{Name: "token-only", Token: "token-a"}}
+
+	sup, err := NewSupervisor(cfg)
+	if err != nil {
+		t.Fatalf("failed to create supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	identity, _ := sup.Identity("token-only")
+	if identity.config.Auth.Secret != "token-a" {
+		t.Errorf("expected the token as secret, got %q", identity.config.Auth.Secret)
+	}
+}
+
+func TestIdentityReconnectsAfterGoAway(t *testing.T) {
+	sign := func(tenant string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":       "office-7",
+			"tenant_id": tenant,
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("shared-secret"))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return token
+	}
+	tokenA, tokenB := sign("tenant-a"), sign("tenant-b")
+
+	tokens := make(chan string, 10)
+	cfg := testConfig()
+	cfg.Relay.Host = "127.0.0.1"
+	cfg.Relay.Port = startFakeRelay(t, tokens, tokenB)
+	cfg.Metrics.Enabled = false
+	cfg.Identities = []types.IdentityConfig{{Name: "a", Token: tokenA}, {Name: "b", Token: tokenB}}
+
+	sup, err := NewSupervisor(cfg)
+	if err != nil {
+		t.Fatalf("failed to create supervisor: %v", err)
+	}
+	defer sup.Stop()
+	if err := sup.Start(); err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	<-tokens
+	<-tokens
+
+	a, _ := sup.Identity("a")
+	b, _ := sup.Identity("b")
+	clientA, clientB := a.Client(), b.Client()
+	if err := clientB.SendHeartbeat(); err == nil {
+		t.Fatal("expected the heartbeat to be answered with a goaway")
+	}
+
+	select {
+	case token := <-tokens:
+		if token != tokenB {
+			t.Errorf("expected identity b to authenticate again, got another token")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("identity b did not reconnect after the goaway")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Client() == clientB || !b.Client().IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("identity b has no connected client after the goaway")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Err() != nil {
+		t.Errorf("expected identity b to run again, got %v", b.Err())
+	}
+	if a.Client() != clientA || !clientA.IsConnected() {
+		t.Error("expected identity a to keep its session")
+	}
+}
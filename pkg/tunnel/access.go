@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// AccessList decides which source addresses may connect to a tunnel
+type AccessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewAccessList parses the CIDRs and single addresses of cfg
+func NewAccessList(cfg types.AccessConfig) (*AccessList, error) {
+	allow, err := parsePrefixes(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow entry: %w", err)
+	}
+	deny, err := parsePrefixes(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny entry: %w", err)
+	}
+	return &AccessList{allow: allow, deny: deny}, nil
+}
+
+// parsePrefixes parses CIDRs such as 10.0.0.0/8 and addresses such as ::1
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Allows reports whether addr may connect. Denied sources are refused; when allow
+// entries exist, only sources matching one of them are accepted.
+func (a *AccessList) Allows(addr netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, prefix := range a.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the list accepts every source
+func (a *AccessList) Empty() bool {
+	return a == nil || len(a.allow) == 0 && len(a.deny) == 0
+}
+
+// checkSource returns an ip_not_allowed error when the access list of the tunnel refuses addr.
+// Sources without an IP address are only accepted when the tunnel has no allow entries.
+func (t *Tunnel) checkSource(addr net.Addr) *errors.RelayError {
+	t.mu.RLock()
+	access := t.access
+	t.mu.RUnlock()
+	if access.Empty() {
+		return nil
+	}
+
+	var source netip.Addr
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		source, _ = netip.AddrFromSlice(tcpAddr.IP)
+	}
+	if !source.IsValid() {
+		if len(access.allow) == 0 {
+			return nil
+		}
+		return errors.NewRelayError(errors.ErrIPNotAllowed, fmt.Sprintf("source %s has no IP address", addr))
+	}
+	if !access.Allows(source) {
+		return errors.NewRelayError(errors.ErrIPNotAllowed, fmt.Sprintf("source %s is not allowed", source.Unmap()))
+	}
+	return nil
+}
+
+// setAccess replaces the access list of the tunnel, applied to new connections
+func (t *Tunnel) setAccess(cfg types.AccessConfig) error {
+	access, err := NewAccessList(cfg)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.access = access
+	t.config.Access = cfg
+	return nil
+}
@@ -0,0 +1,230 @@
+package tunnel
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/errors"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// errTunnelStopped is returned to queued connections of a tunnel that stopped accepting
+var errTunnelStopped = stderrors.New("tunnel stopped")
+
+// connLimiter counts connections against a limit, zero meaning unlimited
+type connLimiter struct {
+	scope  string
+	limit  int
+	active int
+	// released is closed and replaced whenever a slot may have become free
+	released chan struct{}
+	mu       sync.Mutex
+}
+
+// newConnLimiter creates a limiter; scope names it in rejection messages
+func newConnLimiter(scope string, limit int) *connLimiter {
+	return &connLimiter{scope: scope, limit: limit, released: make(chan struct{})}
+}
+
+// tryAcquire takes a slot, or returns a channel closed once a slot may be free
+func (l *connLimiter) tryAcquire() (bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit > 0 && l.active >= l.limit {
+		return false, l.released
+	}
+	l.active++
+	return true, nil
+}
+
+// release frees a slot taken with tryAcquire
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.notify()
+}
+
+// setLimit changes the limit; connections above a lowered limit are kept
+func (l *connLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.notify()
+}
+
+// notify wakes the connections waiting for a slot
+func (l *connLimiter) notify() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// limitError describes the limit as the connection_limit_reached error
+func (l *connLimiter) limitError() *errors.RelayError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return errors.NewRelayError(errors.ErrConnectionLimitReached,
+		fmt.Sprintf("%s limit of %d connections reached", l.scope, l.limit))
+}
+
+// SetConnectionLimits sets the cap on connections of all tunnels together and the
+// default behavior of tunnels at their limits
+func (m *Manager) SetConnectionLimits(cfg types.ConnectionsConfig) {
+	m.mu.Lock()
+	m.connections = cfg
+	m.mu.Unlock()
+
+	m.slots.setLimit(cfg.MaxConnections)
+}
+
+// admissionPolicy returns what happens to a new connection of tunnel at a limit
+func (m *Manager) admissionPolicy(tunnel *Tunnel) (onLimit string, queueTimeout time.Duration, maxQueue int) {
+	m.mu.RLock()
+	defaults := m.connections
+	m.mu.RUnlock()
+
+	limits := tunnel.Config().Limits
+	onLimit, queueTimeout, maxQueue = limits.OnLimit, limits.QueueTimeout, limits.MaxQueue
+	if onLimit == "" {
+		onLimit = defaults.OnLimit
+	}
+	if queueTimeout <= 0 {
+		queueTimeout = defaults.QueueTimeout
+	}
+	if maxQueue <= 0 {
+		maxQueue = defaults.MaxQueue
+	}
+	if maxQueue <= 0 {
+		maxQueue = types.DefaultMaxQueue
+	}
+	return onLimit, queueTimeout, maxQueue
+}
+
+// tryAcquireSlots takes a slot of the tunnel and of the client. On failure it returns
+// the limiter that is full and a channel closed once it may have room.
+func (m *Manager) tryAcquireSlots(tunnel *Tunnel) (*connLimiter, <-chan struct{}) {
+	if ok, released := tunnel.slots.tryAcquire(); !ok {
+		return tunnel.slots, released
+	}
+	if ok, released := m.slots.tryAcquire(); !ok {
+		tunnel.slots.release()
+		return m.slots, released
+	}
+	return nil, nil
+}
+
+// releaseSlots frees the slots taken by tryAcquireSlots
+func (m *Manager) releaseSlots(tunnel *Tunnel) {
+	m.slots.release()
+	tunnel.slots.release()
+}
+
+// waitForSlots waits up to timeout for slots of the tunnel and the client
+func (m *Manager) waitForSlots(tunnel *Tunnel, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		full, released := m.tryAcquireSlots(tunnel)
+		if full == nil {
+			return nil
+		}
+		select {
+		case <-released:
+		case <-timer.C:
+			return full.limitError()
+		case <-tunnel.stopped:
+			return errTunnelStopped
+		}
+	}
+}
+
+// admitConnection applies the connection limits to a newly accepted connection and
+// starts handling it. It reports false when the tunnel no longer accepts connections.
+func (m *Manager) admitConnection(tunnel *Tunnel, conn net.Conn) bool {
+	full, _ := m.tryAcquireSlots(tunnel)
+	if full != nil {
+		onLimit, queueTimeout, maxQueue := m.admissionPolicy(tunnel)
+		// A full queue rejects like reject mode, so a flood cannot pile up waiting connections
+		if onLimit != types.OnLimitQueue || !tunnel.enqueue(maxQueue) {
+			m.rejectConnection(tunnel, conn, full.limitError())
+			return true
+		}
+		go m.queueConnection(tunnel, conn, queueTimeout)
+		return true
+	}
+
+	// Count the connection before handing it off so a drain waits for it
+	if !tunnel.admit() {
+		m.releaseSlots(tunnel)
+		closeRejected(conn)
+		return false
+	}
+	go m.handleTunnelConnection(tunnel, conn)
+	return true
+}
+
+// queueConnection holds a connection until the limits leave room for it or timeout passes
+func (m *Manager) queueConnection(tunnel *Tunnel, conn net.Conn, timeout time.Duration) {
+	tunnel.Stats.RecordQueued()
+	if connMetrics := m.getMetrics(); connMetrics != nil {
+		connMetrics.RecordConnectionQueued(tunnel.ID, m.tenantID())
+	}
+
+	err := m.waitForSlots(tunnel, timeout)
+	tunnel.dequeue()
+	if err != nil {
+		var relayErr *errors.RelayError
+		if stderrors.As(err, &relayErr) {
+			m.rejectConnection(tunnel, conn, relayErr)
+		} else {
+			closeRejected(conn)
+		}
+		return
+	}
+
+	if !tunnel.admit() {
+		m.releaseSlots(tunnel)
+		closeRejected(conn)
+		return
+	}
+	m.handleTunnelConnection(tunnel, conn)
+}
+
+// enqueue counts a connection waiting for a slot unless limit connections already wait
+func (t *Tunnel) enqueue(limit int) bool {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if t.queued >= limit {
+		return false
+	}
+	t.queued++
+	return true
+}
+
+// dequeue forgets a connection counted by enqueue once it stopped waiting
+func (t *Tunnel) dequeue() {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	t.queued--
+}
+
+// rejectConnection closes a connection refused by admission control and records why
+func (m *Manager) rejectConnection(tunnel *Tunnel, conn net.Conn, reason *errors.RelayError) {
+	m.logf("Rejected connection for tunnel %s from %s: %v\n", tunnel.ID, conn.RemoteAddr(), reason)
+	tunnel.Stats.RecordRejected()
+	if connMetrics := m.getMetrics(); connMetrics != nil {
+		connMetrics.RecordConnectionRejected(tunnel.ID, m.tenantID(), reason.Code)
+	}
+	closeRejected(conn)
+}
+
+// closeRejected closes a connection that is not handled
+func closeRejected(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		_ = err // Игнорируем ошибку закрытия отклоненного соединения
+	}
+}
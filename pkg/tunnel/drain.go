@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDrainTimeout is how long a drain waits for active connections when the tunnel sets no drain_timeout
+const DefaultDrainTimeout = 30 * time.Second
+
+const (
+	// drainPollInterval is how often a drain checks the active connection count
+	drainPollInterval = 50 * time.Millisecond
+	// drainCloseTimeout bounds the wait for handlers to exit after their connections were closed
+	drainCloseTimeout = 5 * time.Second
+)
+
+// DrainResult describes how a tunnel drain ended
+type DrainResult struct {
+	TunnelID string
+	// Completed is the number of connections that finished before the deadline
+	Completed int
+	// ForceClosed is the number of connections closed when the deadline passed
+	ForceClosed int
+	Duration    time.Duration
+}
+
+// Drain is a tunnel drain in progress
+type Drain struct {
+	result DrainResult
+	done   chan struct{}
+}
+
+// Wait blocks until the drain has finished and returns its result
+func (d *Drain) Wait() DrainResult {
+	<-d.done
+	return d.result
+}
+
+// drainTimeout returns the deadline a drain of tunnel uses when the caller gives none
+func drainTimeout(tunnel *Tunnel, timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	if configured := tunnel.Config().DrainTimeout; configured > 0 {
+		return configured
+	}
+	return DefaultDrainTimeout
+}
+
+// StartDrain removes a tunnel and stops accepting connections for it; its port is free
+// once StartDrain returns. Active connections are then given until timeout to finish,
+// zero meaning the tunnel's drain_timeout, and the remaining ones are closed.
+func (m *Manager) StartDrain(tunnelID string, timeout time.Duration) (*Drain, error) {
+	m.mu.Lock()
+	tunnel, exists := m.tunnels[tunnelID]
+	if !exists {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("tunnel %s not found", tunnelID)
+	}
+	delete(m.tunnels, tunnelID)
+	m.mu.Unlock()
+
+	tunnel.stop()
+
+	var closeErr error
+	if tunnel.listener != nil {
+		if err := tunnel.listener.Close(); err != nil && !stderrors.Is(err, net.ErrClosed) {
+			closeErr = fmt.Errorf("failed to close listener for tunnel %s: %w", tunnelID, err)
+		}
+	}
+
+	drain := &Drain{
+		result: DrainResult{TunnelID: tunnelID},
+		done:   make(chan struct{}),
+	}
+	go m.runDrain(tunnel, drainTimeout(tunnel, timeout), drain)
+
+	return drain, closeErr
+}
+
+// runDrain waits for the connections of a stopped tunnel and force-closes them at the deadline
+func (m *Manager) runDrain(tunnel *Tunnel, timeout time.Duration, drain *Drain) {
+	defer close(drain.done)
+
+	startedAt := time.Now()
+	initial := int(tunnel.Stats.GetActiveConnections())
+	remaining := waitForConnections(tunnel, timeout)
+
+	drain.result.Completed = initial - remaining
+	if remaining > 0 {
+		drain.result.ForceClosed = remaining
+		tunnel.closeConns()
+		if left := waitForConnections(tunnel, drainCloseTimeout); left > 0 {
+			m.logf("Tunnel %s: %d connections did not stop after being closed\n", tunnel.ID, left)
+		}
+	}
+	drain.result.Duration = time.Since(startedAt)
+
+	if initial > 0 {
+		m.logf("Tunnel %s drained in %v: %d connections completed, %d force-closed\n",
+			tunnel.ID, drain.result.Duration.Round(time.Millisecond), drain.result.Completed, drain.result.ForceClosed)
+	}
+}
+
+// waitForConnections waits until tunnel has no active connections or timeout passed
+// and returns the number still active
+func waitForConnections(tunnel *Tunnel, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		active := int(tunnel.Stats.GetActiveConnections())
+		if active <= 0 {
+			return 0
+		}
+		if !time.Now().Before(deadline) {
+			return active
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// DrainTunnel stops a tunnel and waits for its connections as described for StartDrain
+func (m *Manager) DrainTunnel(tunnelID string, timeout time.Duration) (DrainResult, error) {
+	drain, err := m.StartDrain(tunnelID, timeout)
+	if drain == nil {
+		return DrainResult{TunnelID: tunnelID}, err
+	}
+	return drain.Wait(), err
+}
+
+// DrainAll stops every tunnel and drains them concurrently, each with its own deadline
+// unless timeout is set
+func (m *Manager) DrainAll(timeout time.Duration) []DrainResult {
+	m.mu.RLock()
+	ids := make([]string, 0, len(m.tunnels))
+	for id := range m.tunnels {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+
+	results := make([]DrainResult, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			result, err := m.DrainTunnel(id, timeout)
+			if err != nil {
+				m.logf("Failed to drain tunnel %s: %v\n", id, err)
+			}
+			results[i] = result
+		}(i, id)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// listen opens the listener of a tunnel: TCP on its bind address or a Unix socket
+// with the configured file mode and owner
+func listen(cfg types.TunnelConfig) (net.Listener, error) {
+	if !cfg.IsUnixSocket() {
+		return net.Listen("tcp", cfg.ListenAddress())
+	}
+
+	path := cfg.SocketPath()
+	mode, uid, gid, err := socketSettings(cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketSettings(path, mode, uid, gid); err != nil {
+		if closeErr := listener.Close(); closeErr != nil {
+			_ = closeErr // Игнорируем ошибку закрытия, сокет не настроен
+		}
+		return nil, err
+	}
+	return listener, nil
+}
+
+// socketSettings parses the file mode and owner of a Unix socket, -1 leaving an ID unchanged
+func socketSettings(cfg types.SocketConfig) (mode os.FileMode, uid, gid int, err error) {
+	modeText := cfg.Mode
+	if modeText == "" {
+		modeText = types.DefaultSocketMode
+	}
+	parsed, err := strconv.ParseUint(modeText, 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, 0, 0, fmt.Errorf("invalid socket mode %q", cfg.Mode)
+	}
+
+	uid, gid = -1, -1
+	if cfg.Owner != "" {
+		if uid, err = lookupID(cfg.Owner, lookupUser); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid socket owner %q: %w", cfg.Owner, err)
+		}
+	}
+	if cfg.Group != "" {
+		if gid, err = lookupID(cfg.Group, lookupGroup); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid socket group %q: %w", cfg.Group, err)
+		}
+	}
+	return os.FileMode(parsed), uid, gid, nil
+}
+
+// lookupID returns a numeric ID as is and resolves names with lookup
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// lookupUser returns the user ID of a user name
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+// lookupGroup returns the group ID of a group name
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a previous process. Files that are
+// not sockets and sockets something still listens on are kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		if err := conn.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия при проверке сокета
+		}
+		return fmt.Errorf("socket %s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// applySocketSettings sets the file mode and owner of a socket created by listen
+func applySocketSettings(path string, mode os.FileMode, uid, gid int) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set socket owner: %w", err)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,17 @@
 package tunnel
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/2gc-dev/cloudbridge-client/pkg/auth"
 	"github.com/2gc-dev/cloudbridge-client/pkg/interfaces"
+	"github.com/2gc-dev/cloudbridge-client/pkg/metrics"
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
 )
 
 // BufferManager manages buffer pools for efficient data transfer
@@ -61,15 +66,50 @@ func (bm *BufferManager) ReturnBuffer(buf []byte) {
 // Tunnel represents a tunnel configuration
 type Tunnel struct {
 	ID         string
+	Bind       string
 	LocalPort  int
 	RemoteHost string
 	RemotePort int
+	Protocol   string
+	Limits     types.TunnelLimitsConfig
+	Labels     map[string]string
 	Active     bool
+	config     types.TunnelConfig
+	listener   net.Listener
 	CreatedAt  time.Time
 	LastUsed   time.Time
 	BufferMgr  *BufferManager
 	Stats      *TunnelStats
 	mu         sync.RWMutex // Mutex for Active field
+	// conns holds the local and remote connections of the tunnel so a drain can close them
+	conns       map[net.Conn]struct{}
+	connsClosed bool
+	// closing is closed together with the connections to interrupt throttled transfers
+	closing chan struct{}
+	// stopped is closed when the tunnel stops accepting connections
+	stopped chan struct{}
+	// slots counts the connections against limits.max_connections, queued those waiting
+	// for one under connsMu
+	slots  *connLimiter
+	queued int
+	// access holds the source address allow and deny lists
+	access *AccessList
+	// bandwidth limits the whole tunnel, connBandwidth holds the limiters of each connection
+	bandwidth     *bandwidthLimiters
+	connBandwidth map[*bandwidthLimiters]struct{}
+	connsMu       sync.Mutex
+}
+
+// Config returns the configuration the tunnel was created from, including runtime updates
+func (t *Tunnel) Config() types.TunnelConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config
+}
+
+// LocalAddress returns the host:port or unix:// address the tunnel listens on
+func (t *Tunnel) LocalAddress() string {
+	return t.Config().ListenAddress()
 }
 
 // IsActive safely checks if tunnel is active
@@ -86,13 +126,120 @@ func (t *Tunnel) SetActive(active bool) {
 	t.Active = active
 }
 
+// stop marks the tunnel inactive and wakes its queued connections
+func (t *Tunnel) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Active {
+		close(t.stopped)
+	}
+	t.Active = false
+}
+
+// admit counts a new connection unless the tunnel stopped accepting, so a drain
+// never misses a connection accepted while it started
+func (t *Tunnel) admit() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.Active {
+		return false
+	}
+	t.Stats.IncrementConnections()
+	return true
+}
+
+// trackConn registers a connection to be closed by a drain.
+// It returns false when the tunnel connections were already force-closed.
+func (t *Tunnel) trackConn(conn net.Conn) bool {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if t.connsClosed {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+// untrackConn removes a connection registered with trackConn
+func (t *Tunnel) untrackConn(conn net.Conn) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	delete(t.conns, conn)
+}
+
+// closeConns closes every tracked connection and refuses new ones
+func (t *Tunnel) closeConns() {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if !t.connsClosed {
+		close(t.closing)
+	}
+	t.connsClosed = true
+	for conn := range t.conns {
+		if err := conn.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия при принудительном завершении
+		}
+	}
+	t.conns = nil
+}
+
+// forceClosed reports whether a drain closed the tunnel connections
+func (t *Tunnel) forceClosed() bool {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	return t.connsClosed
+}
+
+// newConnBandwidth creates the bandwidth limiters of a new connection
+func (t *Tunnel) newConnBandwidth() *bandwidthLimiters {
+	limiters := newBandwidthLimiters(t.Config().Limits.ConnectionBandwidth)
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	if t.connBandwidth == nil {
+		t.connBandwidth = make(map[*bandwidthLimiters]struct{})
+	}
+	t.connBandwidth[limiters] = struct{}{}
+	return limiters
+}
+
+// releaseConnBandwidth forgets the limiters of a finished connection
+func (t *Tunnel) releaseConnBandwidth(limiters *bandwidthLimiters) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	delete(t.connBandwidth, limiters)
+}
+
+// setBandwidth changes the limits of the tunnel and of its active connections
+func (t *Tunnel) setBandwidth(tunnel, connection types.BandwidthConfig) {
+	t.mu.Lock()
+	t.config.Limits.Bandwidth = tunnel
+	t.config.Limits.ConnectionBandwidth = connection
+	t.mu.Unlock()
+
+	t.bandwidth.set(tunnel)
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	for limiters := range t.connBandwidth {
+		limiters.set(connection)
+	}
+}
+
 // TunnelStats represents tunnel statistics
 type TunnelStats struct {
 	BytesTransferred   int64
 	ConnectionsHandled int64
 	ActiveConnections  int32
-	LastActivity       time.Time
-	mu                 sync.RWMutex
+	// ConnectionsRejected counts connections refused by admission control,
+	// ConnectionsQueued those that had to wait for a free slot
+	ConnectionsRejected int64
+	ConnectionsQueued   int64
+	// ConnectionsClosed counts finished connections by close reason such as idle_timeout
+	ConnectionsClosed map[string]int64
+	LastActivity      time.Time
+	mu                sync.RWMutex
 }
 
 // NewTunnelStats creates new tunnel statistics
@@ -127,16 +274,55 @@ func (ts *TunnelStats) DecrementConnections() {
 	ts.LastActivity = time.Now()
 }
 
+// RecordRejected counts a connection refused by admission control
+func (ts *TunnelStats) RecordRejected() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.ConnectionsRejected++
+}
+
+// RecordQueued counts a connection queued for a free slot
+func (ts *TunnelStats) RecordQueued() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.ConnectionsQueued++
+}
+
+// RecordClosed counts a finished connection by the reason it ended
+func (ts *TunnelStats) RecordClosed(reason string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.ConnectionsClosed == nil {
+		ts.ConnectionsClosed = make(map[string]int64)
+	}
+	ts.ConnectionsClosed[reason]++
+}
+
+// GetActiveConnections returns the number of active connections
+func (ts *TunnelStats) GetActiveConnections() int32 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.ActiveConnections
+}
+
 // GetStats returns a copy of current statistics
 func (ts *TunnelStats) GetStats() map[string]interface{} {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
+	closed := make(map[string]int64, len(ts.ConnectionsClosed))
+	for reason, count := range ts.ConnectionsClosed {
+		closed[reason] = count
+	}
+
 	return map[string]interface{}{
-		"bytes_transferred":   ts.BytesTransferred,
-		"connections_handled": ts.ConnectionsHandled,
-		"active_connections":  ts.ActiveConnections,
-		"last_activity":       ts.LastActivity,
+		"bytes_transferred":    ts.BytesTransferred,
+		"connections_handled":  ts.ConnectionsHandled,
+		"active_connections":   ts.ActiveConnections,
+		"connections_rejected": ts.ConnectionsRejected,
+		"connections_queued":   ts.ConnectionsQueued,
+		"connections_closed":   closed,
+		"last_activity":        ts.LastActivity,
 	}
 }
 
@@ -144,7 +330,12 @@ func (ts *TunnelStats) GetStats() map[string]interface{} {
 type Manager struct {
 	client  interfaces.ClientInterface
 	tunnels map[string]*Tunnel
-	mu      sync.RWMutex
+	scope   *auth.TunnelScope
+	metrics *metrics.Metrics
+	// connections holds the admission defaults, slots counts connections of all tunnels
+	connections types.ConnectionsConfig
+	slots       *connLimiter
+	mu          sync.RWMutex
 }
 
 // NewManager creates a new tunnel manager
@@ -152,38 +343,125 @@ func NewManager(client interfaces.ClientInterface) *Manager {
 	return &Manager{
 		client:  client,
 		tunnels: make(map[string]*Tunnel),
+		slots:   newConnLimiter("client", 0),
 	}
 }
 
-// RegisterTunnel registers a new tunnel
+// SetMetrics sets the metrics system that tunnel traffic is recorded to
+func (m *Manager) SetMetrics(metrics *metrics.Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = metrics
+}
+
+// tenantID returns the tenant of the owning client
+func (m *Manager) tenantID() string {
+	if m.client == nil {
+		return ""
+	}
+	return m.client.GetTenantID()
+}
+
+// logf prints a log line labelled with the tenant of the owning client
+func (m *Manager) logf(format string, args ...interface{}) {
+	if tenantID := m.tenantID(); tenantID != "" {
+		format = "[tenant=" + tenantID + "] " + format
+	}
+	fmt.Printf(format, args...)
+}
+
+// getMetrics returns the metrics system, nil if none is set
+func (m *Manager) getMetrics() *metrics.Metrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.metrics
+}
+
+// SetScope sets the token scope that tunnel requests are checked against
+func (m *Manager) SetScope(scope *auth.TunnelScope) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scope = scope
+}
+
+// AuthorizeTunnel checks a tunnel request against the token scope
+func (m *Manager) AuthorizeTunnel(localPort int, remoteHost string, remotePort int) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.scope.Allows(localPort, remoteHost, remotePort)
+}
+
+// RegisterTunnel registers a new TCP tunnel listening on loopback
 func (m *Manager) RegisterTunnel(tunnelID string, localPort int, remoteHost string, remotePort int) error {
+	return m.RegisterTunnelConfig(types.TunnelConfig{
+		ID:         tunnelID,
+		LocalPort:  localPort,
+		RemoteHost: remoteHost,
+		RemotePort: remotePort,
+	})
+}
+
+// RegisterTunnelConfig registers a new tunnel from its declarative configuration
+func (m *Manager) RegisterTunnelConfig(cfg types.TunnelConfig) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Enforce token scope
+	if err := m.scope.Allows(cfg.LocalPort, cfg.RemoteHost, cfg.RemotePort); err != nil {
+		return err
+	}
+
 	// Validate tunnel parameters
-	if err := m.validateTunnelParams(localPort, remoteHost, remotePort); err != nil {
+	if err := m.validateTunnelParams(cfg); err != nil {
 		return fmt.Errorf("invalid tunnel parameters: %w", err)
 	}
 
 	// Check if tunnel already exists
-	if _, exists := m.tunnels[tunnelID]; exists {
-		return fmt.Errorf("tunnel %s already exists", tunnelID)
+	if _, exists := m.tunnels[cfg.ID]; exists {
+		return fmt.Errorf("tunnel %s already exists", cfg.ID)
+	}
+
+	access, err := NewAccessList(cfg.Access)
+	if err != nil {
+		return fmt.Errorf("invalid tunnel access list: %w", err)
+	}
+
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = types.TunnelProtocolTCP
 	}
 
 	// Create tunnel
 	tunnel := &Tunnel{
-		ID:         tunnelID,
-		LocalPort:  localPort,
-		RemoteHost: remoteHost,
-		RemotePort: remotePort,
+		ID:         cfg.ID,
+		Bind:       cfg.Bind,
+		LocalPort:  cfg.LocalPort,
+		RemoteHost: cfg.RemoteHost,
+		RemotePort: cfg.RemotePort,
+		Protocol:   protocol,
+		Limits:     cfg.Limits,
+		Labels:     cfg.Labels,
 		CreatedAt:  time.Now(),
 		LastUsed:   time.Now(),
 		BufferMgr:  NewBufferManager(4096, 100),
 		Stats:      NewTunnelStats(),
+		config:     cfg,
+		closing:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+		slots:      newConnLimiter("tunnel", cfg.Limits.MaxConnections),
+		access:     access,
+		bandwidth:  newBandwidthLimiters(cfg.Limits.Bandwidth),
+	}
+
+	// Listen before registering so bind errors are reported to the caller
+	listener, err := listen(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", tunnel.LocalAddress(), err)
 	}
+	tunnel.listener = listener
 	tunnel.SetActive(true)
 
-	m.tunnels[tunnelID] = tunnel
+	m.tunnels[cfg.ID] = tunnel
 
 	// Start tunnel proxy
 	go m.startTunnelProxy(tunnel)
@@ -191,22 +469,58 @@ func (m *Manager) RegisterTunnel(tunnelID string, localPort int, remoteHost stri
 	return nil
 }
 
-// UnregisterTunnel removes a tunnel
+// UnregisterTunnel removes a tunnel and stops accepting connections for it.
+// Established connections are drained in the background, see StartDrain.
 func (m *Manager) UnregisterTunnel(tunnelID string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	_, err := m.StartDrain(tunnelID, 0)
+	return err
+}
 
-	tunnel, exists := m.tunnels[tunnelID]
+// SetBandwidth changes the bandwidth limits of a running tunnel and of its active connections
+func (m *Manager) SetBandwidth(tunnelID string, tunnel, connection types.BandwidthConfig) error {
+	t, exists := m.GetTunnel(tunnelID)
 	if !exists {
 		return fmt.Errorf("tunnel %s not found", tunnelID)
 	}
+	t.setBandwidth(tunnel, connection)
+	return nil
+}
 
-	tunnel.SetActive(false)
-	delete(m.tunnels, tunnelID)
+// UpdateTunnel applies the settings of cfg that a running tunnel can change without being
+// recreated, see Diff.Updated
+func (m *Manager) UpdateTunnel(cfg types.TunnelConfig) error {
+	t, exists := m.GetTunnel(cfg.ID)
+	if !exists {
+		return fmt.Errorf("tunnel %s not found", cfg.ID)
+	}
+	if err := t.setAccess(cfg.Access); err != nil {
+		return fmt.Errorf("invalid tunnel access list: %w", err)
+	}
+	t.setBandwidth(cfg.Limits.Bandwidth, cfg.Limits.ConnectionBandwidth)
+	t.slots.setLimit(cfg.Limits.MaxConnections)
 
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Limits = cfg.Limits
+	t.config.Limits = cfg.Limits
+	t.config.DrainTimeout = cfg.DrainTimeout
+	t.config.IdleTimeout = cfg.IdleTimeout
+	t.config.MaxConnectionLifetime = cfg.MaxConnectionLifetime
 	return nil
 }
 
+// TunnelConfigs returns the configurations of all registered tunnels
+func (m *Manager) TunnelConfigs() []types.TunnelConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configs := make([]types.TunnelConfig, 0, len(m.tunnels))
+	for _, tunnel := range m.tunnels {
+		configs = append(configs, tunnel.Config())
+	}
+	return configs
+}
+
 // GetTunnel returns a tunnel by ID
 func (m *Manager) GetTunnel(tunnelID string) (*Tunnel, bool) {
 	m.mu.RLock()
@@ -230,41 +544,66 @@ func (m *Manager) ListTunnels() []*Tunnel {
 }
 
 // validateTunnelParams validates tunnel parameters
-func (m *Manager) validateTunnelParams(localPort int, remoteHost string, remotePort int) error {
-	// Validate local port
-	if localPort <= 0 || localPort > 65535 {
-		return fmt.Errorf("invalid local port: %d", localPort)
-	}
-
+func (m *Manager) validateTunnelParams(cfg types.TunnelConfig) error {
 	// Validate remote host
-	if remoteHost == "" {
+	if cfg.RemoteHost == "" {
 		return fmt.Errorf("remote host cannot be empty")
 	}
 
 	// Validate remote port
-	if remotePort <= 0 || remotePort > 65535 {
-		return fmt.Errorf("invalid remote port: %d", remotePort)
+	if cfg.RemotePort <= 0 || cfg.RemotePort > 65535 {
+		return fmt.Errorf("invalid remote port: %d", cfg.RemotePort)
+	}
+
+	// Unix socket tunnels have no local port; listen reports sockets already in use
+	if cfg.IsUnixSocket() {
+		if cfg.SocketPath() == "" {
+			return fmt.Errorf("unix socket path cannot be empty")
+		}
+		if m.isSocketInUse(cfg.SocketPath()) {
+			return fmt.Errorf("socket %s is already in use", cfg.SocketPath())
+		}
+		return nil
+	}
+
+	// Validate local port
+	if cfg.LocalPort <= 0 || cfg.LocalPort > 65535 {
+		return fmt.Errorf("invalid local port: %d", cfg.LocalPort)
 	}
 
 	// Check if local port is already in use
-	if m.isPortInUse(localPort) {
-		return fmt.Errorf("local port %d is already in use", localPort)
+	if m.isPortInUse(cfg.BindHost(), cfg.LocalPort) {
+		return fmt.Errorf("local port %d is already in use", cfg.LocalPort)
 	}
 
 	return nil
 }
 
-// isPortInUse checks if a port is already in use
-func (m *Manager) isPortInUse(port int) bool {
-	// Check if any existing tunnel uses this port
+// isSocketInUse checks if an active tunnel listens on the Unix socket path
+func (m *Manager) isSocketInUse(path string) bool {
+	for _, tunnel := range m.tunnels {
+		if tunnel.IsActive() && tunnel.Config().SocketPath() == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isPortInUse checks if a port is already in use on the bind address
+func (m *Manager) isPortInUse(bind string, port int) bool {
+	// Check if any existing tunnel uses this port on an overlapping address
 	for _, tunnel := range m.tunnels {
-		if tunnel.LocalPort == port && tunnel.IsActive() {
+		cfg := tunnel.Config()
+		if cfg.IsUnixSocket() || !tunnel.IsActive() {
+			continue
+		}
+		if tunnel.LocalPort == port && types.BindsOverlap(cfg.BindHost(), bind) {
 			return true
 		}
 	}
 
 	// Check if port is actually in use by trying to bind to it
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	ln, err := net.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(port)))
 	if err != nil {
 		_ = err // Игнорируем ошибку закрытия при проверке порта
 		return true
@@ -275,54 +614,108 @@ func (m *Manager) isPortInUse(port int) bool {
 	return false
 }
 
-// startTunnelProxy starts a proxy for the tunnel
+// startTunnelProxy accepts connections on the tunnel listener until the tunnel is unregistered
 func (m *Manager) startTunnelProxy(tunnel *Tunnel) {
-	// Listen on local port
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", tunnel.LocalPort))
-	if err != nil {
-		fmt.Printf("Failed to start tunnel %s: %v\n", tunnel.ID, err)
-		return
-	}
-	defer func() { if err := listener.Close(); err != nil { fmt.Printf("Failed to close listener for tunnel %s: %v\n", tunnel.ID, err) } }()
+	listener := tunnel.listener
+	defer func() {
+		if err := listener.Close(); err != nil && !stderrors.Is(err, net.ErrClosed) {
+			m.logf("Failed to close listener for tunnel %s: %v\n", tunnel.ID, err)
+		}
+	}()
 
-	fmt.Printf("Tunnel %s started: localhost:%d -> %s:%d\n",
-		tunnel.ID, tunnel.LocalPort, tunnel.RemoteHost, tunnel.RemotePort)
+	m.logf("Tunnel %s started: %s -> %s:%d\n",
+		tunnel.ID, tunnel.LocalAddress(), tunnel.RemoteHost, tunnel.RemotePort)
 
 	for tunnel.IsActive() {
 		// Accept local connection
 		localConn, err := listener.Accept()
 		if err != nil {
 			if tunnel.IsActive() {
-				fmt.Printf("Failed to accept connection for tunnel %s: %v\n", tunnel.ID, err)
+				m.logf("Failed to accept connection for tunnel %s: %v\n", tunnel.ID, err)
 			}
 			continue
 		}
 
-		// Handle connection in goroutine
-		go m.handleTunnelConnection(tunnel, localConn)
+		// Refuse sources outside the access lists before they take a connection slot
+		if err := tunnel.checkSource(localConn.RemoteAddr()); err != nil {
+			m.rejectConnection(tunnel, localConn, err)
+			continue
+		}
+
+		// Enforce the connection limits and handle the connection in a goroutine
+		if !m.admitConnection(tunnel, localConn) {
+			break
+		}
 	}
 }
 
-// handleTunnelConnection handles a single tunnel connection
+// handleTunnelConnection handles a single tunnel connection admitted by admitConnection
 func (m *Manager) handleTunnelConnection(tunnel *Tunnel, localConn net.Conn) {
+	defer m.releaseSlots(tunnel)
 	defer func() {
-		if err := localConn.Close(); err != nil {
-			fmt.Printf("Failed to close local connection for tunnel %s: %v\n", tunnel.ID, err)
+		if err := localConn.Close(); err != nil && !stderrors.Is(err, net.ErrClosed) {
+			m.logf("Failed to close local connection for tunnel %s: %v\n", tunnel.ID, err)
 		}
 	}()
 
-	// Update last used time and increment connection count
-	tunnel.LastUsed = time.Now()
-	tunnel.Stats.IncrementConnections()
+	// The connection was counted when it was admitted
+	startedAt := time.Now()
+	tunnel.LastUsed = startedAt
 	defer tunnel.Stats.DecrementConnections()
 
+	// Record why the connection ended in the stats and the access log
+	reason := CloseReasonCompleted
+	var uploaded, downloaded int64
+	defer func() {
+		m.recordClosed(tunnel, localConn, reason, time.Since(startedAt), uploaded, downloaded)
+	}()
+
+	if !tunnel.trackConn(localConn) {
+		reason = CloseReasonDrained
+		return
+	}
+	defer tunnel.untrackConn(localConn)
+
+	// Record the connection with the tenant of the owning client
+	tenantID := m.tenantID()
+	connMetrics := m.getMetrics()
+	if connMetrics != nil {
+		connMetrics.RecordConnectionHandled(tunnel.ID, tenantID)
+		connMetrics.SetActiveConnections(tunnel.ID, tenantID, int(tunnel.Stats.GetActiveConnections()))
+		defer func() {
+			connMetrics.RecordConnectionDuration(tunnel.ID, tenantID, time.Since(startedAt))
+			connMetrics.SetActiveConnections(tunnel.ID, tenantID, int(tunnel.Stats.GetActiveConnections())-1)
+		}()
+	}
+
 	// Connect to remote host
-	remoteConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", tunnel.RemoteHost, tunnel.RemotePort))
+	remoteConn, err := net.Dial("tcp", net.JoinHostPort(tunnel.RemoteHost, strconv.Itoa(tunnel.RemotePort)))
 	if err != nil {
-		fmt.Printf("Failed to connect to remote host for tunnel %s: %v\n", tunnel.ID, err)
+		m.logf("Failed to connect to remote host for tunnel %s: %v\n", tunnel.ID, err)
+		reason = CloseReasonDialFailed
+		if connMetrics != nil {
+			connMetrics.RecordError("remote_dial", tunnel.ID, tenantID)
+		}
 		return
 	}
-	defer func() { if err := remoteConn.Close(); err != nil { fmt.Printf("Failed to close remote connection for tunnel %s: %v\n", tunnel.ID, err) } }()
+	defer func() {
+		if err := remoteConn.Close(); err != nil && !stderrors.Is(err, net.ErrClosed) {
+			m.logf("Failed to close remote connection for tunnel %s: %v\n", tunnel.ID, err)
+		}
+	}()
+	if !tunnel.trackConn(remoteConn) {
+		reason = CloseReasonDrained
+		return
+	}
+	defer tunnel.untrackConn(remoteConn)
+
+	// Limit the bandwidth of the connection on top of the tunnel limits
+	connBandwidth := tunnel.newConnBandwidth()
+	defer tunnel.releaseConnBandwidth(connBandwidth)
+
+	// Close both sides once the connection is idle or open for too long
+	watchdog := newConnWatchdog(tunnel.Config(), startedAt)
+	go watchdog.run(localConn, remoteConn)
 
 	// Start bidirectional data transfer
 	done := make(chan bool, 2)
@@ -337,13 +730,24 @@ func (m *Manager) handleTunnelConnection(tunnel *Tunnel, localConn net.Conn) {
 				break
 			}
 			if n > 0 {
+				watchdog.beginTransfer()
+				if waited := tunnel.throttle(n, tunnel.bandwidth.upload, connBandwidth.upload); waited > 0 && connMetrics != nil {
+					connMetrics.RecordThrottled(tunnel.ID, tenantID, "upload", waited)
+				}
 				_, err = remoteConn.Write(buffer[:n])
+				watchdog.endTransfer()
 				if err != nil {
 					break
 				}
+				uploaded += int64(n)
 				tunnel.Stats.UpdateBytesTransferred(int64(n))
+				if connMetrics != nil {
+					connMetrics.RecordBytesTransferred(tunnel.ID, tenantID, "upload", int64(n))
+				}
 			}
 		}
+		// Pass the end of the stream on so the remote side finishes too
+		closeWrite(remoteConn)
 		done <- true
 	}()
 
@@ -357,19 +761,47 @@ func (m *Manager) handleTunnelConnection(tunnel *Tunnel, localConn net.Conn) {
 				break
 			}
 			if n > 0 {
+				watchdog.beginTransfer()
+				if waited := tunnel.throttle(n, tunnel.bandwidth.download, connBandwidth.download); waited > 0 && connMetrics != nil {
+					connMetrics.RecordThrottled(tunnel.ID, tenantID, "download", waited)
+				}
 				_, err = localConn.Write(buffer[:n])
+				watchdog.endTransfer()
 				if err != nil {
 					break
 				}
+				downloaded += int64(n)
 				tunnel.Stats.UpdateBytesTransferred(int64(n))
+				if connMetrics != nil {
+					connMetrics.RecordBytesTransferred(tunnel.ID, tenantID, "download", int64(n))
+				}
 			}
 		}
+		closeWrite(localConn)
 		done <- true
 	}()
 
 	// Wait for both directions to complete
 	<-done
 	<-done
+
+	if expired := watchdog.finish(); expired != "" {
+		reason = expired
+	} else if tunnel.forceClosed() {
+		reason = CloseReasonDrained
+	}
+}
+
+// closeWrite shuts down the writing side of conn, closing it entirely when it cannot half-close
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := halfCloser.CloseWrite(); err == nil {
+			return
+		}
+	}
+	if err := conn.Close(); err != nil {
+		_ = err // Игнорируем ошибку закрытия, соединение уже могло быть закрыто
+	}
 }
 
 // GetTunnelStats returns statistics for all tunnels
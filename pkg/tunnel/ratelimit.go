@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// RateLimiter is a token bucket limiting a byte stream to a rate with bursts
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewRateLimiter creates a limiter of rate bytes per second, zero for unlimited.
+// Up to burst bytes pass at once, one second of traffic when burst is zero.
+func NewRateLimiter(rate, burst int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst, keeping the tokens already collected up to the new burst
+func (l *RateLimiter) SetLimit(rate, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.rate > 0 {
+		l.refill(now)
+	}
+
+	wasUnlimited := l.rate <= 0
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	if burst <= 0 {
+		l.burst = l.rate
+	}
+	if wasUnlimited || l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// Limit returns the rate in bytes per second and the burst, zero rate meaning unlimited
+func (l *RateLimiter) Limit() (rate, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate), int64(l.burst)
+}
+
+// Reserve takes n bytes from the bucket and returns how long the caller has to wait
+// before sending them. The bucket may go into debt, so chunks larger than the burst pass too.
+func (l *RateLimiter) Reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// refill adds the tokens collected since the last update
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// bandwidthLimiters limits both directions of a tunnel or a connection
+type bandwidthLimiters struct {
+	upload   *RateLimiter
+	download *RateLimiter
+}
+
+// newBandwidthLimiters creates limiters for the rates of cfg
+func newBandwidthLimiters(cfg types.BandwidthConfig) *bandwidthLimiters {
+	return &bandwidthLimiters{
+		upload:   NewRateLimiter(cfg.Upload, cfg.UploadBurst),
+		download: NewRateLimiter(cfg.Download, cfg.DownloadBurst),
+	}
+}
+
+// set changes the rates to those of cfg
+func (b *bandwidthLimiters) set(cfg types.BandwidthConfig) {
+	b.upload.SetLimit(cfg.Upload, cfg.UploadBurst)
+	b.download.SetLimit(cfg.Download, cfg.DownloadBurst)
+}
+
+// throttle waits until n bytes may pass every limiter, or until the tunnel connections are
+// force-closed, and returns the time spent waiting
+func (t *Tunnel) throttle(n int, limiters ...*RateLimiter) time.Duration {
+	var wait time.Duration
+	for _, limiter := range limiters {
+		if d := limiter.Reserve(n); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	startedAt := time.Now()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.closing:
+	}
+	return time.Since(startedAt)
+}
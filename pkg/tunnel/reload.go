@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// Diff describes the changes needed to turn the running tunnels into the desired set
+type Diff struct {
+	Added   []types.TunnelConfig
+	Changed []types.TunnelConfig
+	// Updated tunnels only differ in settings applied without recreating them, see Manager.UpdateTunnel
+	Updated   []types.TunnelConfig
+	Removed   []string
+	Unchanged []string
+}
+
+// Empty reports whether the diff contains no changes
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// DiffTunnels compares running tunnel configurations with the desired ones.
+// Disabled tunnels in desired are treated as absent.
+func DiffTunnels(current, desired []types.TunnelConfig) *Diff {
+	running := make(map[string]types.TunnelConfig, len(current))
+	for _, cfg := range current {
+		running[cfg.ID] = cfg
+	}
+
+	diff := &Diff{}
+	wanted := make(map[string]bool, len(desired))
+	for _, cfg := range desired {
+		if !cfg.IsEnabled() {
+			continue
+		}
+		wanted[cfg.ID] = true
+
+		existing, exists := running[cfg.ID]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, cfg)
+		case !reflect.DeepEqual(listenerSettings(existing), listenerSettings(cfg)):
+			diff.Changed = append(diff.Changed, cfg)
+		case !reflect.DeepEqual(normalizeTunnelConfig(existing), normalizeTunnelConfig(cfg)):
+			diff.Updated = append(diff.Updated, cfg)
+		default:
+			diff.Unchanged = append(diff.Unchanged, cfg.ID)
+		}
+	}
+
+	for id := range running {
+		if !wanted[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Removed)
+
+	return diff
+}
+
+// normalizeTunnelConfig clears fields whose zero values are equivalent to their defaults
+func normalizeTunnelConfig(cfg types.TunnelConfig) types.TunnelConfig {
+	cfg.Enabled = nil
+	if cfg.Protocol == "" {
+		cfg.Protocol = types.TunnelProtocolTCP
+	}
+	if len(cfg.Labels) == 0 {
+		cfg.Labels = nil
+	}
+	if len(cfg.Access.Allow) == 0 {
+		cfg.Access.Allow = nil
+	}
+	if len(cfg.Access.Deny) == 0 {
+		cfg.Access.Deny = nil
+	}
+	return cfg
+}
+
+// listenerSettings returns cfg without the settings a running tunnel can change in place
+func listenerSettings(cfg types.TunnelConfig) types.TunnelConfig {
+	cfg = normalizeTunnelConfig(cfg)
+	cfg.Limits = types.TunnelLimitsConfig{}
+	cfg.Access = types.AccessConfig{}
+	cfg.DrainTimeout = 0
+	cfg.IdleTimeout = 0
+	cfg.MaxConnectionLifetime = 0
+	return cfg
+}
@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/2gc-dev/cloudbridge-client/pkg/types"
+)
+
+// Reasons a tunnel connection ended, recorded in the tunnel stats, metrics and access log
+const (
+	// CloseReasonCompleted means either side closed the connection
+	CloseReasonCompleted = "completed"
+	// CloseReasonIdleTimeout means no bytes passed in either direction for idle_timeout
+	CloseReasonIdleTimeout = "idle_timeout"
+	// CloseReasonMaxLifetime means the connection was open for max_connection_lifetime
+	CloseReasonMaxLifetime = "max_connection_lifetime"
+	// CloseReasonDrained means a drain closed the connection at its deadline
+	CloseReasonDrained = "drained"
+	// CloseReasonDialFailed means the remote host could not be reached
+	CloseReasonDialFailed = "dial_failed"
+)
+
+// connWatchdog closes a connection that is idle or open for too long
+type connWatchdog struct {
+	idleTimeout time.Duration
+	lifetime    time.Duration
+	// lastActive is the time of the last transfer in unix nanoseconds
+	lastActive atomic.Int64
+	// transfers counts the chunks being throttled or written, which keep the connection active
+	transfers atomic.Int32
+	// reason is set before done is closed when the watchdog closed the connection
+	reason string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newConnWatchdog creates a watchdog for the idle_timeout and max_connection_lifetime of cfg;
+// lifetime counts from startedAt
+func newConnWatchdog(cfg types.TunnelConfig, startedAt time.Time) *connWatchdog {
+	w := &connWatchdog{
+		idleTimeout: cfg.IdleTimeout,
+		lifetime:    cfg.MaxConnectionLifetime - time.Since(startedAt),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if cfg.MaxConnectionLifetime <= 0 {
+		w.lifetime = 0
+	} else if w.lifetime <= 0 {
+		// The lifetime passed while the remote host was dialed
+		w.lifetime = time.Nanosecond
+	}
+	w.touch()
+	return w
+}
+
+// touch records a transfer on the connection
+func (w *connWatchdog) touch() {
+	w.lastActive.Store(time.Now().UnixNano())
+}
+
+// beginTransfer marks a chunk as in transfer until endTransfer, so waiting for the
+// bandwidth limit or a slow peer does not count as idle time
+func (w *connWatchdog) beginTransfer() {
+	w.transfers.Add(1)
+	w.touch()
+}
+
+// endTransfer records the end of a chunk transfer started with beginTransfer
+func (w *connWatchdog) endTransfer() {
+	w.touch()
+	w.transfers.Add(-1)
+}
+
+// run closes conns when a timeout passes, until finish is called
+func (w *connWatchdog) run(conns ...net.Conn) {
+	defer close(w.done)
+
+	var idle, lifetime <-chan time.Time
+	var idleTimer *time.Timer
+	if w.idleTimeout > 0 {
+		idleTimer = time.NewTimer(w.idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if w.lifetime > 0 {
+		lifetimeTimer := time.NewTimer(w.lifetime)
+		defer lifetimeTimer.Stop()
+		lifetime = lifetimeTimer.C
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-lifetime:
+			w.expire(CloseReasonMaxLifetime, conns)
+			return
+		case <-idle:
+			idleFor := time.Since(time.Unix(0, w.lastActive.Load()))
+			if w.transfers.Load() > 0 {
+				idleFor = 0
+			}
+			if idleFor >= w.idleTimeout {
+				w.expire(CloseReasonIdleTimeout, conns)
+				return
+			}
+			idleTimer.Reset(w.idleTimeout - idleFor)
+		}
+	}
+}
+
+// expire records reason and closes both sides of the connection
+func (w *connWatchdog) expire(reason string, conns []net.Conn) {
+	w.reason = reason
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil {
+			_ = err // Игнорируем ошибку закрытия, соединение уже могло быть закрыто
+		}
+	}
+}
+
+// finish stops the watchdog and returns the reason it closed the connection, empty if it did not
+func (w *connWatchdog) finish() string {
+	close(w.stop)
+	<-w.done
+	return w.reason
+}
+
+// recordClosed counts a finished connection by the reason it ended and writes its access log line
+func (m *Manager) recordClosed(tunnel *Tunnel, conn net.Conn, reason string, duration time.Duration, uploaded, downloaded int64) {
+	tunnel.Stats.RecordClosed(reason)
+	if connMetrics := m.getMetrics(); connMetrics != nil {
+		connMetrics.RecordConnectionClosed(tunnel.ID, m.tenantID(), reason)
+	}
+	m.logf("Connection closed for tunnel %s from %s: reason=%s duration=%v uploaded=%d downloaded=%d\n",
+		tunnel.ID, conn.RemoteAddr(), reason, duration.Round(time.Millisecond), uploaded, downloaded)
+}
@@ -1,6 +1,10 @@
 package types
 
 import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,12 +22,198 @@ const (
 
 // Config represents the complete client configuration
 type Config struct {
+	// Version is the configuration file format version, older files are migrated on load
+	Version      int                `mapstructure:"version"`
 	Relay        RelayConfig        `mapstructure:"relay"`
 	Auth         AuthConfig         `mapstructure:"auth"`
 	RateLimiting RateLimitingConfig `mapstructure:"rate_limiting"`
 	Logging      LoggingConfig      `mapstructure:"logging"`
 	Metrics      MetricsConfig      `mapstructure:"metrics"`
 	Performance  PerformanceConfig  `mapstructure:"performance"`
+	Connections  ConnectionsConfig  `mapstructure:"connections"`
+	Tunnels      []TunnelConfig     `mapstructure:"tunnels"`
+	Identities   []IdentityConfig   `mapstructure:"identities"`
+	Encryption   EncryptionConfig   `mapstructure:"encryption"`
+	// CurrentContext selects the entry of Contexts applied over relay, auth and tunnels
+	CurrentContext string          `mapstructure:"current_context"`
+	Contexts       []ContextConfig `mapstructure:"contexts"`
+}
+
+// EncryptionConfig contains settings for enc:v1 encrypted configuration values
+type EncryptionConfig struct {
+	// KeyFile holds the base64 value key, config.key next to the configuration file when empty
+	KeyFile string `mapstructure:"key_file"`
+}
+
+// ContextConfig is a named bundle of relay, auth and tunnel settings.
+// Settings given in the context override the top-level ones; tunnels replace them.
+type ContextConfig struct {
+	Name    string         `mapstructure:"name"`
+	Relay   RelayConfig    `mapstructure:"relay"`
+	Auth    AuthConfig     `mapstructure:"auth"`
+	Tunnels []TunnelConfig `mapstructure:"tunnels"`
+}
+
+// IdentityConfig describes a named identity with its own token, relay connection and tunnels.
+// Empty relay and auth fields inherit the top-level settings.
+type IdentityConfig struct {
+	Name      string         `mapstructure:"name"`
+	Token     string         `mapstructure:"token"`
+	TokenFile string         `mapstructure:"token_file"`
+	Relay     RelayConfig    `mapstructure:"relay"`
+	Auth      AuthConfig     `mapstructure:"auth"`
+	Tunnels   []TunnelConfig `mapstructure:"tunnels"`
+}
+
+// Tunnel protocols
+const (
+	TunnelProtocolTCP = "tcp"
+)
+
+const (
+	// DefaultTunnelBind is the address tunnels listen on when bind is empty
+	DefaultTunnelBind = "127.0.0.1"
+	// UnixSocketScheme prefixes bind addresses of Unix socket listeners
+	UnixSocketScheme = "unix://"
+	// DefaultSocketMode is the file mode of Unix socket listeners when socket.mode is empty
+	DefaultSocketMode = "0600"
+)
+
+// Behaviors when a connection limit is reached
+const (
+	// OnLimitReject closes new connections at once
+	OnLimitReject = "reject"
+	// OnLimitQueue holds new connections until a slot is free or the queue timeout passes
+	OnLimitQueue = "queue"
+)
+
+// DefaultMaxQueue is how many connections a tunnel holds in queue mode when max_queue is not set
+const DefaultMaxQueue = 100
+
+// ConnectionsConfig contains admission control settings shared by all tunnels of a client
+type ConnectionsConfig struct {
+	// MaxConnections caps the connections of all tunnels together, zero means unlimited
+	MaxConnections int `mapstructure:"max_connections"`
+	// OnLimit is reject or queue, used by tunnels that do not set their own
+	OnLimit      string        `mapstructure:"on_limit"`
+	QueueTimeout time.Duration `mapstructure:"queue_timeout"`
+	// MaxQueue caps the connections waiting for a slot per tunnel, zero uses DefaultMaxQueue
+	MaxQueue int `mapstructure:"max_queue"`
+}
+
+// TunnelConfig describes a tunnel to create on startup
+type TunnelConfig struct {
+	ID string `mapstructure:"id"`
+	// Enabled defaults to true when omitted
+	Enabled *bool `mapstructure:"enabled"`
+	// Bind is the local address to listen on: an IP such as 127.0.0.1 or [::1], 0.0.0.0 or [::]
+	// for all interfaces, or unix:///path.sock for a Unix socket. Empty means loopback.
+	Bind string `mapstructure:"bind"`
+	// Socket sets the file mode and owner of a unix:// listener
+	Socket     SocketConfig       `mapstructure:"socket"`
+	LocalPort  int                `mapstructure:"local_port"`
+	RemoteHost string             `mapstructure:"remote_host"`
+	RemotePort int                `mapstructure:"remote_port"`
+	Protocol   string             `mapstructure:"protocol"`
+	Limits     TunnelLimitsConfig `mapstructure:"limits"`
+	Access     AccessConfig       `mapstructure:"access"`
+	Labels     map[string]string  `mapstructure:"labels"`
+	// DrainTimeout is how long active connections may finish when the tunnel is stopped,
+	// zero uses the default of 30s
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	// IdleTimeout closes connections without traffic in either direction for this long and
+	// MaxConnectionLifetime closes connections open for this long, zero disabling either
+	IdleTimeout           time.Duration `mapstructure:"idle_timeout"`
+	MaxConnectionLifetime time.Duration `mapstructure:"max_connection_lifetime"`
+}
+
+// IsEnabled reports whether the tunnel should be created
+func (t TunnelConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// IsUnixSocket reports whether the tunnel listens on a Unix socket
+func (t TunnelConfig) IsUnixSocket() bool {
+	return strings.HasPrefix(t.Bind, UnixSocketScheme)
+}
+
+// SocketPath returns the file path of a unix:// bind address, empty for TCP tunnels
+func (t TunnelConfig) SocketPath() string {
+	if !t.IsUnixSocket() {
+		return ""
+	}
+	return strings.TrimPrefix(t.Bind, UnixSocketScheme)
+}
+
+// BindHost returns the host a TCP tunnel listens on without brackets, loopback when bind is empty
+func (t TunnelConfig) BindHost() string {
+	host := strings.TrimSuffix(strings.TrimPrefix(t.Bind, "["), "]")
+	if host == "" {
+		return DefaultTunnelBind
+	}
+	return host
+}
+
+// ListenAddress returns the host:port or unix:// address the tunnel listens on
+func (t TunnelConfig) ListenAddress() string {
+	if t.IsUnixSocket() {
+		return t.Bind
+	}
+	return net.JoinHostPort(t.BindHost(), strconv.Itoa(t.LocalPort))
+}
+
+// BindsOverlap reports whether listeners on the TCP hosts a and b can conflict for one port.
+// An empty host or an unspecified address such as 0.0.0.0 covers every interface.
+func BindsOverlap(a, b string) bool {
+	return a == b || isAllInterfaces(a) || isAllInterfaces(b)
+}
+
+// isAllInterfaces reports whether host listens on every interface
+func isAllInterfaces(host string) bool {
+	if host == "" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsUnspecified()
+}
+
+// SocketConfig contains the file settings of a Unix socket listener
+type SocketConfig struct {
+	// Mode is the octal file mode such as "0660", 0600 when empty
+	Mode string `mapstructure:"mode"`
+	// Owner and Group are user and group names or numeric IDs, the process owner when empty
+	Owner string `mapstructure:"owner"`
+	Group string `mapstructure:"group"`
+}
+
+// AccessConfig restricts the source addresses of tunnel connections with CIDRs or
+// single addresses. Deny entries win; when allow entries exist only matching sources connect.
+type AccessConfig struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+}
+
+// TunnelLimitsConfig contains per-tunnel resource limits, zero means unlimited
+type TunnelLimitsConfig struct {
+	MaxConnections int `mapstructure:"max_connections"`
+	// OnLimit, QueueTimeout and MaxQueue override the connections settings when set
+	OnLimit      string        `mapstructure:"on_limit"`
+	QueueTimeout time.Duration `mapstructure:"queue_timeout"`
+	MaxQueue     int           `mapstructure:"max_queue"`
+	// Bandwidth limits the traffic of the whole tunnel
+	Bandwidth BandwidthConfig `mapstructure:"bandwidth"`
+	// ConnectionBandwidth limits the traffic of each connection
+	ConnectionBandwidth BandwidthConfig `mapstructure:"connection_bandwidth"`
+}
+
+// BandwidthConfig contains token bucket rates in bytes per second, zero means unlimited.
+// Upload is traffic from local clients to the remote host.
+type BandwidthConfig struct {
+	Upload   int64 `mapstructure:"upload"`
+	Download int64 `mapstructure:"download"`
+	// Bursts are the bytes that may pass at once, one second of traffic when zero
+	UploadBurst   int64 `mapstructure:"upload_burst"`
+	DownloadBurst int64 `mapstructure:"download_burst"`
 }
 
 // RelayConfig contains relay server connection settings
@@ -36,20 +226,74 @@ type RelayConfig struct {
 
 // TLSConfig contains TLS-specific settings
 type TLSConfig struct {
-	Enabled    bool   `mapstructure:"enabled"`
-	MinVersion string `mapstructure:"min_version"`
-	VerifyCert bool   `mapstructure:"verify_cert"`
-	CACert     string `mapstructure:"ca_cert"`
-	ClientCert string `mapstructure:"client_cert"`
-	ClientKey  string `mapstructure:"client_key"`
-	ServerName string `mapstructure:"server_name"`
+	Enabled bool `mapstructure:"enabled"`
+	// Profile is the TLS policy: modern, intermediate or custom
+	Profile      string   `mapstructure:"profile"`
+	MinVersion   string   `mapstructure:"min_version"`
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	Curves       []string `mapstructure:"curves"`
+	ALPN         []string `mapstructure:"alpn"`
+	VerifyCert   bool     `mapstructure:"verify_cert"`
+	CACert       string   `mapstructure:"ca_cert"`
+	ClientCert   string   `mapstructure:"client_cert"`
+	ClientKey    string   `mapstructure:"client_key"`
+	ServerName   string   `mapstructure:"server_name"`
+	Pins         []string `mapstructure:"pins"`
+	BackupPins   []string `mapstructure:"backup_pins"`
+	// CertWarnBefore is how long before client certificate expiry a warning is logged
+	CertWarnBefore time.Duration    `mapstructure:"cert_warn_before"`
+	Enrollment     EnrollmentConfig `mapstructure:"enrollment"`
+}
+
+// EnrollmentConfig contains client certificate enrollment settings
+type EnrollmentConfig struct {
+	// URL is the relay enrollment endpoint receiving certificate signing requests
+	URL string `mapstructure:"url"`
+	// RenewBefore is how long before expiry the certificate is renewed,
+	// zero renews after two thirds of the lifetime
+	RenewBefore time.Duration `mapstructure:"renew_before"`
+	// RenewDisabled turns off automatic renewal
+	RenewDisabled bool `mapstructure:"renew_disabled"`
 }
 
 // AuthConfig contains authentication settings
 type AuthConfig struct {
-	Type     string         `mapstructure:"type"`
-	Secret   string         `mapstructure:"secret"`
-	Keycloak KeycloakConfig `mapstructure:"keycloak"`
+	Type            string                `mapstructure:"type"`
+	Secret          string                `mapstructure:"secret"`
+	Keycloak        KeycloakConfig        `mapstructure:"keycloak"`
+	OIDC            OIDCConfig            `mapstructure:"oidc"`
+	MTLS            MTLSConfig            `mapstructure:"mtls"`
+	Validation      ValidationConfig      `mapstructure:"validation"`
+	CredentialStore CredentialStoreConfig `mapstructure:"credential_store"`
+}
+
+// OIDCConfig contains generic OpenID Connect provider settings
+type OIDCConfig struct {
+	IssuerURL string `mapstructure:"issuer_url"`
+	JWKSURL   string `mapstructure:"jwks_url"`
+}
+
+// MTLSConfig contains client certificate identity mapping settings
+type MTLSConfig struct {
+	SubjectSource string `mapstructure:"subject_source"`
+	TenantSource  string `mapstructure:"tenant_source"`
+}
+
+// ValidationConfig contains token claim validation policy settings
+type ValidationConfig struct {
+	Issuer         string        `mapstructure:"issuer"`
+	Audience       []string      `mapstructure:"audience"`
+	RequiredClaims []string      `mapstructure:"required_claims"`
+	Leeway         time.Duration `mapstructure:"leeway"`
+	MaxTokenAge    time.Duration `mapstructure:"max_token_age"`
+}
+
+// CredentialStoreConfig contains encrypted credential cache settings
+type CredentialStoreConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Path     string `mapstructure:"path"`
+	KeyFile  string `mapstructure:"key_file"`
+	Identity string `mapstructure:"identity"`
 }
 
 // KeycloakConfig contains Keycloak integration settings